@@ -6,11 +6,13 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/go-getter"
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/helper/experiment"
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
 )
 
@@ -29,6 +31,7 @@ type ApplyCommand struct {
 
 func (c *ApplyCommand) Run(args []string) int {
 	var destroyForce, refresh bool
+	var timeout, persistInterval time.Duration
 	args = c.Meta.process(args, true)
 
 	cmdName := "apply"
@@ -46,6 +49,9 @@ func (c *ApplyCommand) Run(args []string) int {
 	cmdFlags.StringVar(&c.Meta.statePath, "state", DefaultStateFilename, "path")
 	cmdFlags.StringVar(&c.Meta.stateOutPath, "state-out", "", "path")
 	cmdFlags.StringVar(&c.Meta.backupPath, "backup", "", "path")
+	cmdFlags.DurationVar(&timeout, "timeout", 0, "timeout")
+	cmdFlags.DurationVar(&c.Meta.stateLockTimeout, "lock-timeout", 0, "timeout")
+	cmdFlags.DurationVar(&persistInterval, "state-persist-interval", 0, "interval")
 	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -96,6 +102,14 @@ func (c *ApplyCommand) Run(args []string) int {
 		}
 	}
 
+	// Lock the state so that no one else changes it until we're done
+	unlock, err := c.lockState(cmdName)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error locking state: %s", err))
+		return 1
+	}
+	defer unlock()
+
 	terraform.SetDebugInfo(DefaultDataDir)
 
 	// Check for the legacy graph
@@ -159,6 +173,20 @@ func (c *ApplyCommand) Run(args []string) int {
 			return 1
 		}
 	}
+	if c.Destroy {
+		// Always keep a copy of the state from before the destroy, even
+		// if backups are disabled, since there is no other way back.
+		path, err := c.writePreDestroyBackup()
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf(
+				"Error writing pre-destroy state backup: %s", err))
+			return 1
+		}
+		if path != "" {
+			c.Ui.Output(fmt.Sprintf(
+				"Pre-destroy state backup written to: %s\n", path))
+		}
+	}
 	if !planned {
 		if err := ctx.Input(c.InputMode()); err != nil {
 			c.Ui.Error(fmt.Sprintf("Error configuring: %s", err))
@@ -175,21 +203,46 @@ func (c *ApplyCommand) Run(args []string) int {
 		return 1
 	}
 
+	// If a timeout was given, stop whatever is running once it passes just
+	// as if we had been interrupted, so a stuck operation such as a
+	// provider call that never returns doesn't hang forever.
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
 	// Plan if we haven't already
 	if !planned {
 		if refresh {
-			if _, err := ctx.Refresh(); err != nil {
+			var err error
+			if c.stopOnTimeout(ctx, timeout, timeoutCh, func() {
+				_, err = ctx.Refresh()
+			}) {
+				return 1
+			}
+			if err != nil {
 				c.Ui.Error(fmt.Sprintf("Error refreshing state: %s", err))
 				return 1
 			}
 		}
 
-		if _, err := ctx.Plan(); err != nil {
+		var plan *terraform.Plan
+		var err error
+		if c.stopOnTimeout(ctx, timeout, timeoutCh, func() {
+			plan, err = ctx.Plan()
+		}) {
+			return 1
+		}
+		if err != nil {
 			c.Ui.Error(fmt.Sprintf(
 				"Error creating plan: %s", err))
 			return 1
 		}
 
+		c.warnUnmatchedTargets(plan)
+
 		// Record any shadow errors for later
 		if err := ctx.ShadowError(); err != nil {
 			shadowErr = multierror.Append(shadowErr, multierror.Prefix(
@@ -207,6 +260,7 @@ func (c *ApplyCommand) Run(args []string) int {
 		}
 
 		stateHook.State = state
+		stateHook.PersistInterval = persistInterval
 	}
 
 	// Start the apply in a goroutine so that we can be interrupted.
@@ -227,23 +281,36 @@ func (c *ApplyCommand) Run(args []string) int {
 	// Wait for the apply to finish or for us to be interrupted so
 	// we can handle it properly.
 	err = nil
+	var stop, timedOut bool
 	select {
 	case <-c.ShutdownCh:
 		c.Ui.Output("Interrupt received. Gracefully shutting down...")
+		stop = true
+	case <-timeoutCh:
+		c.Ui.Output(fmt.Sprintf(
+			"Timeout of %s reached. Gracefully shutting down...", timeout))
+		stop = true
+		timedOut = true
+	case <-doneCh:
+	}
 
+	if stop {
 		// Stop execution
 		go ctx.Stop()
 
 		// Still get the result, since there is still one
 		select {
 		case <-c.ShutdownCh:
-			c.Ui.Error(
-				"Two interrupts received. Exiting immediately. Note that data\n" +
-					"loss may have occurred.")
+			msg := "Two interrupts received."
+			if timedOut {
+				msg = "Interrupt received while stopping."
+			}
+
+			c.Ui.Error(msg + " Exiting immediately. Note that data\n" +
+				"loss may have occurred.")
 			return 1
 		case <-doneCh:
 		}
-	case <-doneCh:
 	}
 
 	// Persist the state
@@ -254,6 +321,13 @@ func (c *ApplyCommand) Run(args []string) int {
 		}
 	}
 
+	if timedOut {
+		c.Ui.Error(fmt.Sprintf(
+			"The apply didn't finish within the %s timeout and was stopped.\n"+
+				"Your Terraform state file has been updated with any resources\n"+
+				"that completed before the timeout.", timeout))
+	}
+
 	if applyErr != nil {
 		c.Ui.Error(fmt.Sprintf(
 			"Error applying plan:\n\n"+
@@ -265,6 +339,9 @@ func (c *ApplyCommand) Run(args []string) int {
 			multierror.Flatten(applyErr)))
 		return 1
 	}
+	if timedOut {
+		return 1
+	}
 
 	if c.Destroy {
 		c.Ui.Output(c.Colorize().Color(fmt.Sprintf(
@@ -303,6 +380,61 @@ func (c *ApplyCommand) Run(args []string) int {
 	return 0
 }
 
+// writePreDestroyBackup writes the current state next to the state
+// output path with DefaultPreDestroyBackupExtension, returning the path
+// written. If there is no state, nothing is written and the path is empty.
+func (c *ApplyCommand) writePreDestroyBackup() (string, error) {
+	s, err := c.State()
+	if err != nil {
+		return "", err
+	}
+
+	current := s.State()
+	if current == nil {
+		return "", nil
+	}
+
+	path := c.Meta.StateOutPath() + DefaultPreDestroyBackupExtension
+	ls := &state.LocalState{Path: path}
+	if err := ls.WriteState(current.DeepCopy()); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// stopOnTimeout runs f, which runs an operation on ctx before the apply.
+// If timeoutCh fires first, ctx is stopped and f is waited for. Nothing
+// has been changed yet, so the timeout is reported and true is returned
+// for the caller to exit without applying.
+func (c *ApplyCommand) stopOnTimeout(
+	ctx *terraform.Context,
+	timeout time.Duration,
+	timeoutCh <-chan time.Time,
+	f func()) bool {
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		f()
+	}()
+
+	select {
+	case <-doneCh:
+		return false
+	case <-timeoutCh:
+	}
+
+	c.Ui.Output(fmt.Sprintf(
+		"Timeout of %s reached. Gracefully shutting down...", timeout))
+	go ctx.Stop()
+	<-doneCh
+
+	c.Ui.Error(fmt.Sprintf(
+		"The %s timeout passed before any changes were applied, so the\n"+
+			"apply was stopped without changing anything.", timeout))
+	return true
+}
+
 func (c *ApplyCommand) Help() string {
 	if c.Destroy {
 		return c.helpDestroy()
@@ -345,6 +477,10 @@ Options:
 
   -input=true            Ask for input for variables if not directly set.
 
+  -lock-timeout=0s       Keep retrying to lock the state for this long if
+                         it is locked by someone else, such as "5m".
+                         Defaults to failing at once.
+
   -no-color              If specified, output won't contain any color.
 
   -parallelism=n         Limit the number of concurrent operations.
@@ -360,10 +496,20 @@ Options:
                          "-state". This can be used to preserve the old
                          state.
 
+  -state-persist-interval=0s
+                         Also persist the state this often while the apply
+                         runs, such as "20s", so that less progress is lost
+                         to a crash. Defaults to only persisting at the end.
+
   -target=resource       Resource to target. Operation will be limited to this
                          resource and its dependencies. This flag can be used
                          multiple times.
 
+  -timeout=0s            Stop the apply gracefully, as if interrupted, if it
+                         hasn't finished within this duration, such as "30m".
+                         This includes refreshing and planning. Defaults to
+                         no timeout.
+
   -var 'foo=bar'         Set a variable in the Terraform configuration. This
                          flag can be set multiple times.
 
@@ -390,6 +536,10 @@ Options:
 
   -force                 Don't ask for input for destroy confirmation.
 
+  -lock-timeout=0s       Keep retrying to lock the state for this long if
+                         it is locked by someone else, such as "5m".
+                         Defaults to failing at once.
+
   -no-color              If specified, output won't contain any color.
 
   -parallelism=n         Limit the number of concurrent operations.
@@ -405,10 +555,20 @@ Options:
                          "-state". This can be used to preserve the old
                          state.
 
+  -state-persist-interval=0s
+                         Also persist the state this often while the apply
+                         runs, such as "20s", so that less progress is lost
+                         to a crash. Defaults to only persisting at the end.
+
   -target=resource       Resource to target. Operation will be limited to this
                          resource and its dependencies. This flag can be used
                          multiple times.
 
+  -timeout=0s            Stop the apply gracefully, as if interrupted, if it
+                         hasn't finished within this duration, such as "30m".
+                         This includes refreshing and planning. Defaults to
+                         no timeout.
+
   -var 'foo=bar'         Set a variable in the Terraform configuration. This
                          flag can be set multiple times.
 
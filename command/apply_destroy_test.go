@@ -90,6 +90,23 @@ func TestApply_destroy(t *testing.T) {
 	if actualStr != expectedStr {
 		t.Fatalf("bad:\n\n%s\n\n%s", actualStr, expectedStr)
 	}
+
+	// Should always have a pre-destroy backup file
+	f, err = os.Open(statePath + DefaultPreDestroyBackupExtension)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	backupState, err = terraform.ReadState(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actualStr = strings.TrimSpace(backupState.String())
+	if actualStr != expectedStr {
+		t.Fatalf("bad:\n\n%s\n\n%s", actualStr, expectedStr)
+	}
 }
 
 func TestApply_destroyPlan(t *testing.T) {
@@ -97,6 +114,10 @@ func TestApply_destroyPlan(t *testing.T) {
 		Module: testModule(t, "apply"),
 	})
 
+	// Run in a temporary directory since the default state is locked
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
 	p := testProvider()
 	ui := new(cli.MockUi)
 	c := &ApplyCommand{
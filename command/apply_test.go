@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -15,6 +16,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/cli"
 )
@@ -86,6 +88,89 @@ func (t *hwm) Max() int {
 	return t.max
 }
 
+func TestApply_lock(t *testing.T) {
+	statePath := testTempFile(t)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	// The state is locked for the apply while resources are applied
+	var held *state.LockInfo
+	p.ApplyFn = func(
+		i *terraform.InstanceInfo,
+		s *terraform.InstanceState,
+		d *terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		info, err := (&state.LocalState{Path: statePath}).LockInfo()
+		if err != nil {
+			return nil, err
+		}
+		held = info
+
+		return &terraform.InstanceState{ID: "foo"}, nil
+	}
+
+	args := []string{
+		"-state", statePath,
+		testFixturePath("apply"),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if held == nil || held.Operation != "apply" {
+		t.Fatalf("bad: %#v", held)
+	}
+
+	// The lock is released once the apply is done
+	info, err := (&state.LocalState{Path: statePath}).LockInfo()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info != nil {
+		t.Fatalf("state still locked: %s", info)
+	}
+}
+
+func TestApply_locked(t *testing.T) {
+	statePath := testTempFile(t)
+
+	// Hold the lock as another run would
+	other := &state.LocalState{Path: statePath}
+	id, err := other.Lock(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer other.Unlock(id)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		testFixturePath("apply"),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "is locked") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+	if p.ApplyCalled {
+		t.Fatal("apply should not be called")
+	}
+}
+
 func TestApply_parallelism(t *testing.T) {
 	provider := testProvider()
 	statePath := testTempFile(t)
@@ -679,6 +764,100 @@ func TestApply_plan_remoteState(t *testing.T) {
 	}
 }
 
+func TestApply_remoteStatePersistInterval(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	// A remote that keeps what is pushed to it, so that each persist is
+	// checked against the state from the one before.
+	var lock sync.Mutex
+	var stored []byte
+	puts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		switch req.Method {
+		case "POST":
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				resp.WriteHeader(500)
+				return
+			}
+			stored = body
+			puts++
+		case "GET":
+			if stored == nil {
+				resp.WriteHeader(404)
+				return
+			}
+			resp.Write(stored)
+		}
+	}))
+	defer srv.Close()
+
+	s := terraform.NewState()
+	s.Remote = &terraform.RemoteState{
+		Type:   "http",
+		Config: map[string]string{"address": srv.URL},
+	}
+	testStateFileRemote(t, s)
+
+	p := testProvider()
+	p.DiffFn = func(
+		*terraform.InstanceInfo,
+		*terraform.InstanceState,
+		*terraform.ResourceConfig) (*terraform.InstanceDiff, error) {
+		return &terraform.InstanceDiff{
+			Attributes: map[string]*terraform.ResourceAttrDiff{
+				"ami": &terraform.ResourceAttrDiff{
+					New: "bar",
+				},
+			},
+		}, nil
+	}
+	p.ApplyFn = func(
+		*terraform.InstanceInfo,
+		*terraform.InstanceState,
+		*terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		return &terraform.InstanceState{
+			ID: "foo",
+			Attributes: map[string]string{
+				"ami": "bar",
+			},
+		}, nil
+	}
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-state-persist-interval", "1ns",
+		testFixturePath("apply-persist-interval"),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if puts < 3 {
+		t.Fatalf("expected the state to be persisted several times, got %d", puts)
+	}
+
+	actual, err := terraform.ReadState(bytes.NewReader(stored))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(actual.RootModule().Resources) != 4 {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
 func TestApply_planWithVarFile(t *testing.T) {
 	varFileDir := testTempDir(t)
 	varFilePath := filepath.Join(varFileDir, "terraform.tfvars")
@@ -930,6 +1109,164 @@ func TestApply_shutdown(t *testing.T) {
 	}
 }
 
+func TestApply_timeout(t *testing.T) {
+	stopped := false
+	stopReplyCh := make(chan struct{})
+
+	statePath := testTempFile(t)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	p.DiffFn = func(
+		*terraform.InstanceInfo,
+		*terraform.InstanceState,
+		*terraform.ResourceConfig) (*terraform.InstanceDiff, error) {
+		return &terraform.InstanceDiff{
+			Attributes: map[string]*terraform.ResourceAttrDiff{
+				"ami": &terraform.ResourceAttrDiff{
+					New: "bar",
+				},
+			},
+		}, nil
+	}
+	p.ApplyFn = func(
+		*terraform.InstanceInfo,
+		*terraform.InstanceState,
+		*terraform.InstanceDiff) (*terraform.InstanceState, error) {
+		if !stopped {
+			stopped = true
+			<-stopReplyCh
+		}
+
+		return &terraform.InstanceState{
+			ID: "foo",
+			Attributes: map[string]string{
+				"ami": "2",
+			},
+		}, nil
+	}
+
+	// Hold the first resource well past the timeout, so that the apply
+	// is stopped before the second one starts.
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		close(stopReplyCh)
+	}()
+
+	args := []string{
+		"-state", statePath,
+		"-timeout", "20ms",
+		testFixturePath("apply-shutdown"),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+
+	if !strings.Contains(ui.ErrorWriter.String(), "20ms timeout") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+
+	f, err := os.Open(statePath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+
+	state, err := terraform.ReadState(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if state == nil {
+		t.Fatal("state should not be nil")
+	}
+
+	if len(state.RootModule().Resources) != 1 {
+		t.Fatalf("bad: %d", len(state.RootModule().Resources))
+	}
+}
+
+func TestApply_timeoutRefresh(t *testing.T) {
+	statePath := testStateFile(t, testState())
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	// Hold the refresh well past the timeout
+	p.RefreshFn = func(
+		info *terraform.InstanceInfo,
+		s *terraform.InstanceState) (*terraform.InstanceState, error) {
+		time.Sleep(200 * time.Millisecond)
+		return s, nil
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-timeout", "20ms",
+		testFixturePath("apply"),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "before any changes") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+	if p.DiffCalled || p.ApplyCalled {
+		t.Fatal("plan and apply should not run after the timeout")
+	}
+}
+
+func TestApply_lockTimeout(t *testing.T) {
+	defer func(v time.Duration) { stateLockRetryInterval = v }(stateLockRetryInterval)
+	stateLockRetryInterval = 10 * time.Millisecond
+
+	statePath := testTempFile(t)
+
+	// Hold the lock as another run would, for a little while
+	other := &state.LocalState{Path: statePath}
+	id, err := other.Lock(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		other.Unlock(id)
+	}()
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &ApplyCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		"-lock-timeout", "5s",
+		testFixturePath("apply"),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if !p.ApplyCalled {
+		t.Fatal("apply should be called")
+	}
+}
+
 func TestApply_state(t *testing.T) {
 	originalState := &terraform.State{
 		Modules: []*terraform.ModuleState{
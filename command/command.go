@@ -23,6 +23,10 @@ const DefaultVarsFilename = "terraform.tfvars"
 // DefaultBackupExtension is added to the state file to form the path
 const DefaultBackupExtension = ".backup"
 
+// DefaultPreDestroyBackupExtension is added to the state file to form the
+// path of the backup that destroy always writes before it begins.
+const DefaultPreDestroyBackupExtension = ".predestroy.backup"
+
 // DefaultParallelism is the limit Terraform places on total parallel
 // operations as it walks the dependency graph.
 const DefaultParallelism = 10
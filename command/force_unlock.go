@@ -0,0 +1,136 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/state"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// ForceUnlockCommand is a cli.Command implementation that removes a lock
+// that was left on the state, such as by a crashed run.
+type ForceUnlockCommand struct {
+	Meta
+}
+
+func (c *ForceUnlockCommand) Run(args []string) int {
+	args = c.Meta.process(args, false)
+
+	var force bool
+	cmdFlags := c.Meta.flagSet("force-unlock")
+	cmdFlags.BoolVar(&force, "force", false, "force")
+	cmdFlags.StringVar(&c.Meta.statePath, "state", DefaultStateFilename, "path")
+	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
+	if err := cmdFlags.Parse(args); err != nil {
+		return 1
+	}
+
+	args = cmdFlags.Args()
+	if len(args) != 1 {
+		c.Ui.Error("The force-unlock command expects exactly one argument.")
+		cmdFlags.Usage()
+		return 1
+	}
+	id := args[0]
+
+	st, err := c.State()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to load state: %s", err))
+		return 1
+	}
+
+	locker, ok := st.(state.ForceUnlocker)
+	if !ok {
+		c.Ui.Error("The state doesn't support locking, so it can't be unlocked.")
+		return 1
+	}
+
+	info, err := locker.LockInfo()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading the state lock: %s", err))
+		return 1
+	}
+	if info == nil {
+		c.Ui.Error("The state is not locked.")
+		return 1
+	}
+	if info.ID != id {
+		c.Ui.Error(fmt.Sprintf(
+			"The lock ID %q doesn't match the lock on the state:\n\n%s",
+			id, formatLockInfo(info)))
+		return 1
+	}
+
+	if !force {
+		v, err := c.UIInput().Input(&terraform.InputOpts{
+			Id:    "force-unlock",
+			Query: "Do you really want to force-unlock the state?",
+			Description: fmt.Sprintf(
+				"The state is locked by:\n\n%s\n\n"+
+					"Removing a lock that is still in use can let concurrent runs\n"+
+					"corrupt the state. Only 'yes' will be accepted to confirm.",
+				formatLockInfo(info)),
+		})
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error asking for confirmation: %s", err))
+			return 1
+		}
+		if v != "yes" {
+			c.Ui.Output("force-unlock cancelled.")
+			return 1
+		}
+	}
+
+	if err := locker.ForceUnlock(id); err != nil {
+		c.Ui.Error(fmt.Sprintf("Failed to unlock the state: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(c.Colorize().Color(
+		"[reset][bold][green]The state has been successfully unlocked!"))
+	return 0
+}
+
+// formatLockInfo formats the lock info for showing to the user.
+func formatLockInfo(info *state.LockInfo) string {
+	lines := []string{
+		fmt.Sprintf("  ID:        %s", info.ID),
+		fmt.Sprintf("  Operation: %s", info.Operation),
+		fmt.Sprintf("  Who:       %s", info.Who),
+		fmt.Sprintf("  Version:   %s", info.Version),
+		fmt.Sprintf("  Created:   %s", info.Created),
+	}
+	if info.Info != "" {
+		lines = append(lines, fmt.Sprintf("  Info:      %s", info.Info))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (c *ForceUnlockCommand) Help() string {
+	helpText := `
+Usage: terraform force-unlock [options] LOCK_ID
+
+  Manually unlock the state, removing the lock with the given ID.
+
+  This will not modify your infrastructure. This command removes a lock
+  left on the state, such as by a Terraform run that crashed. The lock
+  holder is shown and confirmation is asked for before the lock is
+  removed.
+
+Options:
+
+  -force              Don't ask for confirmation before unlocking.
+
+  -state=path         Path to read the state from. Defaults to
+                      "terraform.tfstate". Ignored when remote state is
+                      used.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *ForceUnlockCommand) Synopsis() string {
+	return "Manually unlock the terraform state"
+}
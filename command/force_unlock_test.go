@@ -0,0 +1,139 @@
+package command
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/state"
+	"github.com/mitchellh/cli"
+)
+
+func TestForceUnlock(t *testing.T) {
+	is := new(state.InmemState)
+	id, err := is.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &ForceUnlockCommand{
+		Meta: Meta{
+			Ui:    ui,
+			state: is,
+		},
+	}
+
+	if code := c.Run([]string{"-force", id}); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	if info, _ := is.LockInfo(); info != nil {
+		t.Fatalf("state should be unlocked: %s", info)
+	}
+}
+
+func TestForceUnlock_cancel(t *testing.T) {
+	defaultInputReader = bytes.NewBufferString("no\n")
+	defaultInputWriter = new(bytes.Buffer)
+
+	is := new(state.InmemState)
+	id, err := is.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &ForceUnlockCommand{
+		Meta: Meta{
+			Ui:    ui,
+			state: is,
+		},
+	}
+
+	if code := c.Run([]string{id}); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	if info, _ := is.LockInfo(); info == nil {
+		t.Fatal("state should still be locked")
+	}
+}
+
+func TestForceUnlock_wrongID(t *testing.T) {
+	is := new(state.InmemState)
+	if _, err := is.Lock(state.NewLockInfo()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &ForceUnlockCommand{
+		Meta: Meta{
+			Ui:    ui,
+			state: is,
+		},
+	}
+
+	if code := c.Run([]string{"-force", "wrong"}); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "doesn't match") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestForceUnlock_localHeld(t *testing.T) {
+	statePath := testStateFile(t, testState())
+
+	// A lock held by a running process can't be removed
+	ls := &state.LocalState{Path: statePath}
+	id, err := ls.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer ls.Unlock(id)
+
+	ui := new(cli.MockUi)
+	c := &ForceUnlockCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+	}
+
+	args := []string{
+		"-force",
+		"-state", statePath,
+		id,
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "running process") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestForceUnlock_notLocked(t *testing.T) {
+	statePath := testStateFile(t, testState())
+	defer os.Remove(statePath)
+
+	ui := new(cli.MockUi)
+	c := &ForceUnlockCommand{
+		Meta: Meta{
+			Ui: ui,
+		},
+	}
+
+	args := []string{
+		"-force",
+		"-state", statePath,
+		"some-id",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "not locked") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
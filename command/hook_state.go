@@ -1,7 +1,9 @@
 package command
 
 import (
+	"log"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
@@ -14,6 +16,14 @@ type StateHook struct {
 	sync.Mutex
 
 	State state.State
+
+	// PersistInterval, if non-zero, makes the hook also call PersistState
+	// when at least this long has passed since it last did, so that a
+	// crash during a long apply loses less of the progress made. Every
+	// state update is still written with WriteState.
+	PersistInterval time.Duration
+
+	lastPersist time.Time
 }
 
 func (h *StateHook) PostStateUpdate(
@@ -26,6 +36,18 @@ func (h *StateHook) PostStateUpdate(
 		if err := h.State.WriteState(s); err != nil {
 			return terraform.HookActionHalt, err
 		}
+
+		if h.PersistInterval > 0 {
+			if h.lastPersist.IsZero() {
+				h.lastPersist = time.Now()
+			} else if time.Since(h.lastPersist) >= h.PersistInterval {
+				log.Printf("[DEBUG] StateHook: persisting intermediate state")
+				if err := h.State.PersistState(); err != nil {
+					return terraform.HookActionHalt, err
+				}
+				h.lastPersist = time.Now()
+			}
+		}
 	}
 
 	// Continue forth
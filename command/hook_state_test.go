@@ -2,6 +2,7 @@ package command
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
@@ -27,3 +28,37 @@ func TestStateHook(t *testing.T) {
 		t.Fatalf("bad state: %#v", is.State())
 	}
 }
+
+func TestStateHook_persistInterval(t *testing.T) {
+	is := &persistCountState{InmemState: new(state.InmemState)}
+	hook := &StateHook{State: is, PersistInterval: 10 * time.Millisecond}
+
+	s := state.TestStateInitial()
+	for i := 0; i < 2; i++ {
+		if _, err := hook.PostStateUpdate(s); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+	if is.persists != 0 {
+		t.Fatalf("expected no persists before the interval, got %d", is.persists)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := hook.PostStateUpdate(s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if is.persists != 1 {
+		t.Fatalf("expected 1 persist after the interval, got %d", is.persists)
+	}
+}
+
+// persistCountState counts the calls to PersistState.
+type persistCountState struct {
+	*state.InmemState
+	persists int
+}
+
+func (s *persistCountState) PersistState() error {
+	s.persists++
+	return s.InmemState.PersistState()
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/errwrap"
@@ -75,12 +76,20 @@ type Meta struct {
 	// shadow is used to enable/disable the shadow graph
 	//
 	// provider is to specify specific resource providers
-	statePath    string
-	stateOutPath string
-	backupPath   string
-	parallelism  int
-	shadow       bool
-	provider     string
+	//
+	// stateReadOnly wraps the state in a state.ReadOnlyState when it is
+	// loaded, so that it can't be written
+	//
+	// stateLockTimeout is how long to keep retrying to lock a state that
+	// is locked by someone else
+	statePath        string
+	stateOutPath     string
+	backupPath       string
+	parallelism      int
+	shadow           bool
+	provider         string
+	stateReadOnly    bool
+	stateLockTimeout time.Duration
 }
 
 // initStatePaths is used to initialize the default values for
@@ -114,6 +123,10 @@ func (m *Meta) Colorize() *colorstring.Colorize {
 // Context returns a Terraform Context taking into account the context
 // options used to initialize this meta configuration.
 func (m *Meta) Context(copts contextOpts) (*terraform.Context, bool, error) {
+	if err := m.validateTargets(); err != nil {
+		return nil, false, err
+	}
+
 	opts := m.contextOpts()
 
 	// First try to just read the plan directly from the path given.
@@ -163,6 +176,11 @@ func (m *Meta) Context(copts contextOpts) (*terraform.Context, bool, error) {
 	// Tell the context if we're in a destroy plan / apply
 	opts.Destroy = copts.Destroy
 
+	// Guard the state against writes if the caller only reads it
+	if copts.ReadOnly {
+		m.stateReadOnly = true
+	}
+
 	// Store the loaded state
 	state, err := m.State()
 	if err != nil {
@@ -229,13 +247,13 @@ const (
 // terraform.InputMode which is passed directly to Context.Input.
 func (m *Meta) InputMode() terraform.InputMode {
 	if test || !m.input {
-		return 0
+		return terraform.InputModeNone
 	}
 
 	if envVar := os.Getenv(InputModeEnvVar); envVar != "" {
 		if v, err := strconv.ParseBool(envVar); err == nil {
 			if !v {
-				return 0
+				return terraform.InputModeNone
 			}
 		}
 	}
@@ -262,9 +280,66 @@ func (m *Meta) State() (state.State, error) {
 	m.state = result.State
 	m.stateOutPath = result.StatePath
 	m.stateResult = result
+	if m.stateReadOnly {
+		m.state = &state.ReadOnlyState{Real: m.state}
+	}
+
 	return m.state, nil
 }
 
+// stateLockRetryInterval is how long lockState waits between attempts to
+// lock a state that is locked by someone else.
+var stateLockRetryInterval = 1 * time.Second
+
+// lockState takes the lock on the state returned by State, if the state
+// can be locked, for the operation op, and then reads the state again so
+// that the operation starts from the state as it is under the lock. If
+// the state is locked by someone else, it retries until stateLockTimeout
+// passes. The returned function releases the lock, reporting any error
+// to the UI.
+func (m *Meta) lockState(op string) (func(), error) {
+	s, err := m.State()
+	if err != nil {
+		return nil, err
+	}
+
+	l, ok := s.(state.Locker)
+	if !ok {
+		return func() {}, nil
+	}
+
+	// Retry while someone else holds the lock, until the lock timeout
+	info := state.NewLockInfo()
+	info.Operation = op
+	deadline := time.Now().Add(m.stateLockTimeout)
+	var id string
+	for {
+		id, err = l.Lock(info)
+		if _, ok := err.(*state.LockError); !ok || time.Now().After(deadline) {
+			break
+		}
+
+		log.Printf("[INFO] State is locked, retrying: %s", err)
+		time.Sleep(stateLockRetryInterval)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := func() {
+		if err := l.Unlock(id); err != nil {
+			m.Ui.Error(fmt.Sprintf("Error releasing the state lock: %s", err))
+		}
+	}
+
+	if err := s.RefreshState(); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return unlock, nil
+}
+
 // StateRaw is used to setup the state manually.
 func (m *Meta) StateRaw(opts *StateOpts) (*StateResult, error) {
 	result, err := State(opts)
@@ -355,6 +430,45 @@ func (m *Meta) contextOpts() *terraform.ContextOpts {
 	return &opts
 }
 
+// validateTargets parses every -target address so that a malformed one
+// is reported before any work is done, rather than partway through
+// building the graph.
+func (m *Meta) validateTargets() error {
+	var errs []string
+	for _, t := range m.targets {
+		if _, err := terraform.ParseResourceAddress(t); err != nil {
+			errs = append(errs, fmt.Sprintf("  * Invalid target %q: %s", t, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf(
+			"Error parsing -target addresses:\n\n%s", strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+// warnUnmatchedTargets warns about any of the plan's targets that didn't
+// match a resource in the configuration or state, since nothing will be
+// done for them.
+func (m *Meta) warnUnmatchedTargets(p *terraform.Plan) {
+	unmatched, err := p.UnmatchedTargets()
+	if err != nil {
+		log.Printf("[WARN] Error checking targets: %s", err)
+		return
+	}
+	if len(unmatched) == 0 {
+		return
+	}
+
+	m.Ui.Output(m.Colorize().Color(fmt.Sprintf(
+		"[reset][bold][yellow]"+
+			"The following targets didn't match any resources in the configuration\n"+
+			"or the state, so no changes will be made for them. Check them for typos:\n\n"+
+			"  %s\n", strings.Join(unmatched, "\n  "))))
+}
+
 // flags adds the meta flags to the given FlagSet.
 func (m *Meta) flagSet(n string) *flag.FlagSet {
 	f := flag.NewFlagSet(n, flag.ContinueOnError)
@@ -554,4 +668,8 @@ type contextOpts struct {
 
 	// Number of concurrent operations allowed
 	Parallelism int
+
+	// Set to true when the state is only read, such as for a plan. The
+	// state is then wrapped so that it can't be written.
+	ReadOnly bool
 }
@@ -66,6 +66,7 @@ func (c *PlanCommand) Run(args []string) int {
 		Path:        path,
 		StatePath:   c.Meta.statePath,
 		Parallelism: c.Meta.parallelism,
+		ReadOnly:    true,
 	})
 	if err != nil {
 		c.Ui.Error(err.Error())
@@ -122,6 +123,8 @@ func (c *PlanCommand) Run(args []string) int {
 		return 1
 	}
 
+	c.warnUnmatchedTargets(plan)
+
 	if outPath != "" {
 		log.Printf("[INFO] Writing plan output to: %s", outPath)
 		f, err := os.Create(outPath)
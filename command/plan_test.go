@@ -262,6 +262,66 @@ func TestPlan_refresh(t *testing.T) {
 	}
 }
 
+func TestPlan_targetInvalid(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &PlanCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-target", "test_instance",
+		testFixturePath("plan"),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+
+	if p.DiffCalled {
+		t.Fatal("diff should not be called")
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), `Invalid target "test_instance"`) {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+}
+
+func TestPlan_targetUnmatched(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &PlanCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-target", "test_instance.foo",
+		"-target", "test_instance.typo",
+		testFixturePath("plan"),
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	output := ui.OutputWriter.String()
+	if !strings.Contains(output, "  test_instance.typo\n") {
+		t.Fatalf("should warn about the unmatched target:\n\n%s", output)
+	}
+	if strings.Contains(output, "  test_instance.foo\n") {
+		t.Fatalf("should not warn about the matched target:\n\n%s", output)
+	}
+}
+
 func TestPlan_state(t *testing.T) {
 	// Write out some prior state
 	tf, err := ioutil.TempFile("", "tf")
@@ -24,6 +24,7 @@ func (c *RefreshCommand) Run(args []string) int {
 	cmdFlags.IntVar(&c.Meta.parallelism, "parallelism", 0, "parallelism")
 	cmdFlags.StringVar(&c.Meta.stateOutPath, "state-out", "", "path")
 	cmdFlags.StringVar(&c.Meta.backupPath, "backup", "", "path")
+	cmdFlags.DurationVar(&c.Meta.stateLockTimeout, "lock-timeout", 0, "timeout")
 	cmdFlags.Usage = func() { c.Ui.Error(c.Help()) }
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -80,6 +81,14 @@ func (c *RefreshCommand) Run(args []string) int {
 		}
 	}
 
+	// Lock the state so that no one else changes it until we're done
+	unlock, err := c.lockState("refresh")
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error locking state: %s", err))
+		return 1
+	}
+	defer unlock()
+
 	// This is going to keep track of shadow errors
 	var shadowErr error
 
@@ -156,6 +165,10 @@ Options:
 
   -input=true         Ask for input for variables if not directly set.
 
+  -lock-timeout=0s    Keep retrying to lock the state for this long if it
+                      is locked by someone else, such as "5m". Defaults
+                      to failing at once.
+
   -no-color           If specified, output won't contain any color.
 
   -state=path         Path to read and save state (unless state-out
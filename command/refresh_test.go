@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/mitchellh/cli"
 )
@@ -59,6 +60,41 @@ func TestRefresh(t *testing.T) {
 	}
 }
 
+func TestRefresh_locked(t *testing.T) {
+	statePath := testStateFile(t, testState())
+
+	// Hold the lock as another run would
+	other := &state.LocalState{Path: statePath}
+	id, err := other.Lock(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer other.Unlock(id)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &RefreshCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-state", statePath,
+		testFixturePath("refresh"),
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "is locked") {
+		t.Fatalf("bad: %s", ui.ErrorWriter.String())
+	}
+	if p.RefreshCalled {
+		t.Fatal("refresh should not be called")
+	}
+}
+
 func TestRefresh_badState(t *testing.T) {
 	p := testProvider()
 	ui := new(cli.MockUi)
@@ -16,6 +16,7 @@ import (
 type remoteCommandConfig struct {
 	disableRemote bool
 	pullOnDisable bool
+	force         bool
 
 	statePath  string
 	backupPath string
@@ -40,6 +41,7 @@ func (c *RemoteConfigCommand) Run(args []string) int {
 	cmdFlags := flag.NewFlagSet("remote", flag.ContinueOnError)
 	cmdFlags.BoolVar(&c.conf.disableRemote, "disable", false, "")
 	cmdFlags.BoolVar(&c.conf.pullOnDisable, "pull", true, "")
+	cmdFlags.BoolVar(&c.conf.force, "force", false, "")
 	cmdFlags.StringVar(&c.conf.statePath, "state", DefaultStateFilename, "path")
 	cmdFlags.StringVar(&c.conf.backupPath, "backup", "", "path")
 	cmdFlags.StringVar(&c.remoteConf.Type, "backend", "atlas", "")
@@ -263,8 +265,18 @@ func (c *RemoteConfigCommand) updateRemoteConfig() int {
 	// Read in the local state, which is just the cache of the remote state
 	remote := c.stateResult.Remote.Cache
 
-	// Update the configuration
+	// If the state is now stored somewhere else, the cached state is the
+	// state from the old location, and it would be pushed to the new one
+	// the next time it is persisted. Don't do that without being told to.
 	state := remote.State()
+	if state.Remote != nil && !remoteLocationEquals(state.Remote, c.remoteConf) &&
+		state.HasResources() && !c.conf.force {
+		c.Ui.Error(fmt.Sprintf(
+			errRemoteConfigChanged, state.Remote.Type, c.remoteConf.Type))
+		return 1
+	}
+
+	// Update the configuration
 	state.Remote = c.remoteConf
 	if err := remote.WriteState(state); err != nil {
 		c.Ui.Error(fmt.Sprintf("%s", err))
@@ -280,6 +292,45 @@ func (c *RemoteConfigCommand) updateRemoteConfig() int {
 	return 0
 }
 
+// remoteLocationKeys are the configuration keys of each backend that
+// identify where the state is stored. Other keys, such as credentials or
+// the lock table, can change without moving the state.
+var remoteLocationKeys = map[string][]string{
+	"artifactory": {"url", "repo", "subpath"},
+	"atlas":       {"address", "name"},
+	"azure":       {"storage_account_name", "container_name", "key"},
+	"consul":      {"address", "datacenter", "path"},
+	"etcd":        {"endpoints", "path"},
+	"gcs":         {"bucket", "path"},
+	"http":        {"address"},
+	"local":       {"path"},
+	"manta":       {"path"},
+	"pg":          {"conn_str", "schema_name", "name"},
+	"s3":          {"endpoint", "region", "bucket", "key"},
+	"swift":       {"auth_url", "region_name", "path"},
+}
+
+// remoteLocationEquals reports whether a and b store the state in the
+// same place. Backends without known location keys compare the whole
+// configuration.
+func remoteLocationEquals(a, b *terraform.RemoteState) bool {
+	if a.Type != b.Type {
+		return false
+	}
+
+	keys, ok := remoteLocationKeys[a.Type]
+	if !ok {
+		return a.Equals(b)
+	}
+	for _, k := range keys {
+		if a.Config[k] != b.Config[k] {
+			return false
+		}
+	}
+
+	return true
+}
+
 // enableRemoteState is used to enable remote state management
 // and to move a state file into place
 func (c *RemoteConfigCommand) enableRemoteState() int {
@@ -366,6 +417,11 @@ Options:
   -disable               Disables remote state management and migrates the state
                          to the -state path.
 
+  -force                 If remote state is already enabled, allows changing
+                         where it is stored even though the locally cached
+                         state holds resources. The cached state will be
+                         pushed to the new location.
+
   -pull=true             If disabling, this controls if the remote state is
                          pulled before disabling. If enabling, this controls
                          if the remote state is pulled after enabling. This
@@ -383,3 +439,15 @@ Options:
 func (c *RemoteConfigCommand) Synopsis() string {
 	return "Configures remote state management"
 }
+
+const errRemoteConfigChanged = `The remote state configuration has changed.
+
+Remote state is currently stored with the %q backend, and the new
+configuration for the %q backend stores it somewhere else. The locally
+cached state holds resources from the current location, and it would be
+pushed to the new location the next time the state is saved.
+
+If you are moving the state to the new location, run this command again
+with -force. If the new location already holds the state you want, disable
+remote state with "terraform remote config -disable" or remove the cached
+state in .terraform, then configure the new location.`
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/state"
@@ -346,6 +347,119 @@ func TestRemoteConfig_updateRemote(t *testing.T) {
 	}
 }
 
+func TestRemoteConfig_updateRemote_changed(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	// Persist local remote state that has resources
+	s := testState()
+	s.Remote = &terraform.RemoteState{
+		Type:   "http",
+		Config: map[string]string{"address": "http://example.com/old"},
+	}
+
+	statePath := filepath.Join(tmp, DefaultDataDir, DefaultStateFilename)
+	ls := &state.LocalState{Path: statePath}
+	if err := ls.WriteState(s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ls.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &RemoteConfigCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(testProvider()),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{
+		"-backend=http",
+		"-backend-config", "address=http://example.com/new",
+		"-pull=false",
+	}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: \n%s", ui.OutputWriter.String())
+	}
+	if !strings.Contains(ui.ErrorWriter.String(), "configuration has changed") {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	// With -force the change is made
+	ui = new(cli.MockUi)
+	c = &RemoteConfigCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(testProvider()),
+			Ui:          ui,
+		},
+	}
+	if code := c.Run(append([]string{"-force"}, args...)); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	ls = &state.LocalState{Path: statePath}
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if addr := ls.State().Remote.Config["address"]; addr != "http://example.com/new" {
+		t.Fatalf("bad: %s", addr)
+	}
+}
+
+func TestRemoteConfig_updateRemote_credentialsOnly(t *testing.T) {
+	tmp, cwd := testCwd(t)
+	defer testFixCwd(t, tmp, cwd)
+
+	// Persist local remote state that has resources
+	s := testState()
+	s.Remote = &terraform.RemoteState{
+		Type: "http",
+		Config: map[string]string{
+			"address":  "http://example.com",
+			"username": "old",
+		},
+	}
+
+	statePath := filepath.Join(tmp, DefaultDataDir, DefaultStateFilename)
+	ls := &state.LocalState{Path: statePath}
+	if err := ls.WriteState(s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ls.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := new(cli.MockUi)
+	c := &RemoteConfigCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(testProvider()),
+			Ui:          ui,
+		},
+	}
+
+	// Changing only the credentials doesn't need -force
+	args := []string{
+		"-backend=http",
+		"-backend-config", "address=http://example.com",
+		"-backend-config", "username=new",
+		"-backend-config", "password=secret",
+		"-pull=false",
+	}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: \n%s", ui.ErrorWriter.String())
+	}
+
+	ls = &state.LocalState{Path: statePath}
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if user := ls.State().Remote.Config["username"]; user != "new" {
+		t.Fatalf("bad: %s", user)
+	}
+}
+
 // Test enabling remote state
 func TestRemoteConfig_enableRemote(t *testing.T) {
 	tmp, cwd := testCwd(t)
@@ -224,13 +224,16 @@ func remoteState(
 			local.Remote.Type), err)
 	}
 
-	// Create the remote client
-	durable := &remote.State{Client: client}
+	// Create the remote client. Refreshing and persisting are retried so
+	// that a transient network error doesn't fail the whole run.
+	durable := &state.RetryState{Real: &remote.State{Client: client}}
 
-	// Create the cached client
+	// Create the cached client. Persisting checks the remote state first
+	// so that we don't overwrite changes someone else made since we read it.
 	cache := &state.CacheState{
-		Cache:   &state.LocalState{Path: localPath},
-		Durable: durable,
+		Cache:        &state.LocalState{Path: localPath},
+		Durable:      durable,
+		CheckDurable: true,
 	}
 
 	if refresh {
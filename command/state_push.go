@@ -0,0 +1,98 @@
+package command
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform/state"
+	"github.com/mitchellh/cli"
+)
+
+// StatePushCommand is a Command implementation that replaces the state
+// with a state read from a file.
+type StatePushCommand struct {
+	Meta
+	StateMeta
+}
+
+func (c *StatePushCommand) Run(args []string) int {
+	args = c.Meta.process(args, true)
+
+	var force bool
+	cmdFlags := c.Meta.flagSet("state push")
+	cmdFlags.BoolVar(&force, "force", false, "")
+	cmdFlags.StringVar(&c.Meta.statePath, "state", DefaultStateFilename, "path")
+	if err := cmdFlags.Parse(args); err != nil {
+		return cli.RunResultHelp
+	}
+	args = cmdFlags.Args()
+
+	if len(args) != 1 {
+		c.Ui.Error("Exactly one argument expected: path to state to push")
+		return cli.RunResultHelp
+	}
+
+	var raw []byte
+	var err error
+	if args[0] == "-" {
+		raw, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		raw, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(errStatePush, err))
+		return 1
+	}
+
+	s, err := c.StateMeta.State(&c.Meta)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf(errStateLoadingState, err))
+		return 1
+	}
+
+	if err := state.Push(s, raw, force); err != nil {
+		c.Ui.Error(fmt.Sprintf(errStatePush, err))
+		return 1
+	}
+
+	return 0
+}
+
+func (c *StatePushCommand) Help() string {
+	helpText := `
+Usage: terraform state push [options] PATH
+
+  Update the state from the state file at PATH. If PATH is "-",
+  the state is read from stdin.
+
+  The pushed state replaces the current state, whether it is stored
+  locally or remotely. This command creates a timestamped backup of
+  the state before replacing it.
+
+  To protect against losing changes, the push fails if the current
+  state has a different lineage or a newer serial than the state
+  being pushed. These checks can be skipped with -force.
+
+Options:
+
+  -force              Write the state even if the lineage differs or
+                      the serial is older than the current state.
+
+  -state=statefile    Path to a Terraform state file to use to look
+                      up Terraform-managed resources. By default it will
+                      use the state "terraform.tfstate" if it exists.
+
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *StatePushCommand) Synopsis() string {
+	return "Update remote state from a local state file"
+}
+
+const errStatePush = `Error pushing the state: %s
+
+The state was not modified. Please resolve the issue above and
+try again, or use -force to skip the lineage and serial checks.`
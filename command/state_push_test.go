@@ -0,0 +1,102 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/cli"
+)
+
+func TestStatePush(t *testing.T) {
+	current := testState()
+	current.Lineage = "5d1ad1a1-4027-4665-a908-dbe6adff11d8"
+	current.Serial = 2
+	statePath := testStateFile(t, current)
+
+	pushed := current.DeepCopy()
+	pushed.Serial = 3
+	pushed.Modules = nil
+	pushPath := testStateFile(t, pushed)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &StatePushCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{"-state", statePath, pushPath}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+
+	actual := testStatePushRead(t, statePath)
+	if actual.HasResources() || actual.Serial != pushed.Serial {
+		t.Fatalf("bad: %s", actual)
+	}
+
+	// Test we have backups
+	backups := testStateBackups(t, filepath.Dir(statePath))
+	if len(backups) != 1 {
+		t.Fatalf("bad: %#v", backups)
+	}
+}
+
+func TestStatePush_older(t *testing.T) {
+	current := testState()
+	current.Lineage = "5d1ad1a1-4027-4665-a908-dbe6adff11d8"
+	current.Serial = 2
+	statePath := testStateFile(t, current)
+
+	pushed := current.DeepCopy()
+	pushed.Serial = 1
+	pushed.Modules = nil
+	pushPath := testStateFile(t, pushed)
+
+	p := testProvider()
+	ui := new(cli.MockUi)
+	c := &StatePushCommand{
+		Meta: Meta{
+			ContextOpts: testCtxConfig(p),
+			Ui:          ui,
+		},
+	}
+
+	args := []string{"-state", statePath, pushPath}
+	if code := c.Run(args); code != 1 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.OutputWriter.String())
+	}
+	if !testStatePushRead(t, statePath).HasResources() {
+		t.Fatal("state should not have been replaced")
+	}
+
+	// With -force it is replaced
+	ui = new(cli.MockUi)
+	c.Meta.Ui = ui
+	args = []string{"-force", "-state", statePath, pushPath}
+	if code := c.Run(args); code != 0 {
+		t.Fatalf("bad: %d\n\n%s", code, ui.ErrorWriter.String())
+	}
+	if testStatePushRead(t, statePath).HasResources() {
+		t.Fatal("state should have been replaced")
+	}
+}
+
+func testStatePushRead(t *testing.T, path string) *terraform.State {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+
+	s, err := terraform.ReadState(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return s
+}
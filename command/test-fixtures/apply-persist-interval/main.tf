@@ -0,0 +1,15 @@
+resource "test_instance" "foo" {
+    ami = "bar"
+}
+
+resource "test_instance" "bar" {
+    ami = "${test_instance.foo.ami}"
+}
+
+resource "test_instance" "baz" {
+    ami = "${test_instance.bar.ami}"
+}
+
+resource "test_instance" "qux" {
+    ami = "${test_instance.baz.ami}"
+}
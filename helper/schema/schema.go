@@ -141,6 +141,16 @@ type Schema struct {
 	// key.
 	ConflictsWith []string
 
+	// RequiredWith is a set of schema keys that must also be set whenever
+	// this schema is set. Like ConflictsWith, this only checks the
+	// _config_.
+	RequiredWith []string
+
+	// AtMostOneOf is a set of schema keys, conventionally including this
+	// schema's own key, of which at most one may be set in the _config_.
+	// Setting none of them is allowed.
+	AtMostOneOf []string
+
 	// When Deprecated is set, this attribute is deprecated.
 	//
 	// A deprecated field still works, but will probably stop working in near
@@ -561,26 +571,9 @@ func (m schemaMap) InternalValidate(topSchemaMap schemaMap) error {
 
 		if len(v.ConflictsWith) > 0 {
 			for _, key := range v.ConflictsWith {
-				parts := strings.Split(key, ".")
-				sm := topSchemaMap
-				var target *Schema
-				for _, part := range parts {
-					// Skip index fields
-					if _, err := strconv.Atoi(part); err == nil {
-						continue
-					}
-
-					var ok bool
-					if target, ok = sm[part]; !ok {
-						return fmt.Errorf("%s: ConflictsWith references unknown attribute (%s)", k, key)
-					}
-
-					if subResource, ok := target.Elem.(*Resource); ok {
-						sm = schemaMap(subResource.Schema)
-					}
-				}
-				if target == nil {
-					return fmt.Errorf("%s: ConflictsWith cannot find target attribute (%s), sm: %#v", k, key, sm)
+				target, err := topSchemaMap.referencedSchema(k, "ConflictsWith", key)
+				if err != nil {
+					return err
 				}
 				if target.Required {
 					return fmt.Errorf("%s: ConflictsWith cannot contain Required attribute (%s)", k, key)
@@ -592,6 +585,18 @@ func (m schemaMap) InternalValidate(topSchemaMap schemaMap) error {
 			}
 		}
 
+		for _, key := range v.RequiredWith {
+			if _, err := topSchemaMap.referencedSchema(k, "RequiredWith", key); err != nil {
+				return err
+			}
+		}
+
+		for _, key := range v.AtMostOneOf {
+			if _, err := topSchemaMap.referencedSchema(k, "AtMostOneOf", key); err != nil {
+				return err
+			}
+		}
+
 		if v.Type == TypeList || v.Type == TypeSet {
 			if v.Elem == nil {
 				return fmt.Errorf("%s: Elem must be set for lists", k)
@@ -634,6 +639,35 @@ func (m schemaMap) InternalValidate(topSchemaMap schemaMap) error {
 	return nil
 }
 
+// referencedSchema returns the schema of the attribute at key, a dotted
+// path from the top of m, as referenced by field (such as ConflictsWith)
+// of the attribute k. List and set indexes in the path are skipped.
+func (m schemaMap) referencedSchema(k, field, key string) (*Schema, error) {
+	parts := strings.Split(key, ".")
+	sm := m
+	var target *Schema
+	for _, part := range parts {
+		// Skip index fields
+		if _, err := strconv.Atoi(part); err == nil {
+			continue
+		}
+
+		var ok bool
+		if target, ok = sm[part]; !ok {
+			return nil, fmt.Errorf("%s: %s references unknown attribute (%s)", k, field, key)
+		}
+
+		if subResource, ok := target.Elem.(*Resource); ok {
+			sm = schemaMap(subResource.Schema)
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("%s: %s cannot find target attribute (%s), sm: %#v", k, field, key, sm)
+	}
+
+	return target, nil
+}
+
 func (m schemaMap) diff(
 	k string,
 	schema *Schema,
@@ -1110,6 +1144,16 @@ func (m schemaMap) validate(
 		return nil, []error{err}
 	}
 
+	err = m.validateRequiredWithAttributes(k, schema, c)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	err = m.validateAtMostOneOfAttributes(k, schema, c)
+	if err != nil {
+		return nil, []error{err}
+	}
+
 	return m.validateType(k, raw, schema, c)
 }
 
@@ -1132,6 +1176,46 @@ func (m schemaMap) validateConflictingAttributes(
 	return nil
 }
 
+func (m schemaMap) validateRequiredWithAttributes(
+	k string,
+	schema *Schema,
+	c *terraform.ResourceConfig) error {
+
+	for _, requiredKey := range schema.RequiredWith {
+		if _, ok := c.Get(requiredKey); !ok {
+			return fmt.Errorf(
+				"%q: %s must be set when %s is set", k, requiredKey, k)
+		}
+	}
+
+	return nil
+}
+
+func (m schemaMap) validateAtMostOneOfAttributes(
+	k string,
+	schema *Schema,
+	c *terraform.ResourceConfig) error {
+
+	if len(schema.AtMostOneOf) == 0 {
+		return nil
+	}
+
+	var set []string
+	for _, key := range schema.AtMostOneOf {
+		if _, ok := c.Get(key); ok {
+			set = append(set, key)
+		}
+	}
+
+	if len(set) > 1 {
+		return fmt.Errorf(
+			"%q: only one of %s can be set, got %s",
+			k, strings.Join(schema.AtMostOneOf, ", "), strings.Join(set, ", "))
+	}
+
+	return nil
+}
+
 func (m schemaMap) validateList(
 	k string,
 	raw interface{},
@@ -3083,6 +3083,97 @@ func TestSchemaMap_InternalValidate(t *testing.T) {
 			true,
 		},
 
+		"RequiredWith references unknown attribute": {
+			map[string]*Schema{
+				"kms_key_id": &Schema{
+					Type:         TypeString,
+					Optional:     true,
+					RequiredWith: []string{"encrypt"},
+				},
+			},
+			true,
+		},
+
+		"RequiredWith references nested attribute": {
+			map[string]*Schema{
+				"block": &Schema{
+					Type:     TypeList,
+					Optional: true,
+					Elem: &Resource{
+						Schema: map[string]*Schema{
+							"key": &Schema{
+								Type:     TypeString,
+								Optional: true,
+							},
+						},
+					},
+				},
+				"kms_key_id": &Schema{
+					Type:         TypeString,
+					Optional:     true,
+					RequiredWith: []string{"block.0.key"},
+				},
+			},
+			false,
+		},
+
+		"RequiredWith references unknown nested attribute": {
+			map[string]*Schema{
+				"block": &Schema{
+					Type:     TypeList,
+					Optional: true,
+					Elem: &Resource{
+						Schema: map[string]*Schema{
+							"key": &Schema{
+								Type:     TypeString,
+								Optional: true,
+							},
+						},
+					},
+				},
+				"kms_key_id": &Schema{
+					Type:         TypeString,
+					Optional:     true,
+					RequiredWith: []string{"block.0.nope"},
+				},
+			},
+			true,
+		},
+
+		"AtMostOneOf references nested attribute": {
+			map[string]*Schema{
+				"block": &Schema{
+					Type:     TypeList,
+					Optional: true,
+					Elem: &Resource{
+						Schema: map[string]*Schema{
+							"key": &Schema{
+								Type:     TypeString,
+								Optional: true,
+							},
+						},
+					},
+				},
+				"token": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"token", "block.0.key"},
+				},
+			},
+			false,
+		},
+
+		"AtMostOneOf references unknown attribute": {
+			map[string]*Schema{
+				"token": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"token", "certificate"},
+				},
+			},
+			true,
+		},
+
 		"ConflictsWith cannot be used w/ ComputedWhen": {
 			map[string]*Schema{
 				"blacklist": &Schema{
@@ -4050,6 +4141,116 @@ func TestSchemaMap_Validate(t *testing.T) {
 			},
 		},
 
+		"RequiredWith attribute not set generates error": {
+			Schema: map[string]*Schema{
+				"encrypt": &Schema{
+					Type:     TypeBool,
+					Optional: true,
+				},
+				"kms_key_id": &Schema{
+					Type:         TypeString,
+					Optional:     true,
+					RequiredWith: []string{"encrypt"},
+				},
+			},
+
+			Config: map[string]interface{}{
+				"kms_key_id": "key-val",
+			},
+
+			Err: true,
+			Errors: []error{
+				fmt.Errorf(`"kms_key_id": encrypt must be set when kms_key_id is set`),
+			},
+		},
+
+		"RequiredWith attribute set is good": {
+			Schema: map[string]*Schema{
+				"encrypt": &Schema{
+					Type:     TypeBool,
+					Optional: true,
+				},
+				"kms_key_id": &Schema{
+					Type:         TypeString,
+					Optional:     true,
+					RequiredWith: []string{"encrypt"},
+				},
+			},
+
+			Config: map[string]interface{}{
+				"encrypt":    true,
+				"kms_key_id": "key-val",
+			},
+
+			Err: false,
+		},
+
+		"AtMostOneOf with more than one set generates error": {
+			Schema: map[string]*Schema{
+				"auth_token": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+				"client_certificate": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+			},
+
+			Config: map[string]interface{}{
+				"auth_token":         "token",
+				"client_certificate": "cert",
+			},
+
+			Err: true,
+			Errors: []error{
+				fmt.Errorf(`"auth_token": only one of auth_token, client_certificate can be set, got auth_token, client_certificate`),
+				fmt.Errorf(`"client_certificate": only one of auth_token, client_certificate can be set, got auth_token, client_certificate`),
+			},
+		},
+
+		"AtMostOneOf with one set is good": {
+			Schema: map[string]*Schema{
+				"auth_token": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+				"client_certificate": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+			},
+
+			Config: map[string]interface{}{
+				"auth_token": "token",
+			},
+
+			Err: false,
+		},
+
+		"AtMostOneOf with none set is good": {
+			Schema: map[string]*Schema{
+				"auth_token": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+				"client_certificate": &Schema{
+					Type:        TypeString,
+					Optional:    true,
+					AtMostOneOf: []string{"auth_token", "client_certificate"},
+				},
+			},
+
+			Config: map[string]interface{}{},
+
+			Err: false,
+		},
+
 		"Required attribute & undefined conflicting optional are good": {
 			Schema: map[string]*Schema{
 				"required_att": &Schema{
@@ -1,18 +1,59 @@
 package state
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/hashicorp/terraform/terraform"
 )
 
 // BackupState wraps a State that backs up the state on the first time that
 // a WriteState or PersistState is called.
 //
-// If Path exists, it will be overwritten.
+// Path may contain the strftime-style placeholders %Y, %m, %d, %H, %M and
+// %S, which are expanded to the current UTC time when the backup is
+// written. If the resulting path exists, it will be overwritten.
+//
+// Failing to write the backup doesn't fail the WriteState or
+// PersistState call: a warning is logged with the backup path and the
+// real state is still written. Only one backup attempt is made.
+//
+// If Keep is greater than zero and Path has timestamp placeholders, only
+// the newest Keep backups matching Path are kept: older ones are removed
+// after each new backup is written.
+//
+// If ChecksumPath is set, the SHA-256 checksums of the real state file
+// and the backup are recorded there whenever the real state file is
+// written, and RefreshState verifies the files against them before
+// reading. A file that doesn't match is only warned about, since other
+// runs of Terraform may have legitimately changed it. Only the file of a
+// real state that is a *LocalState can be checksummed.
+//
+// WriteState and PersistState are safe to call concurrently; the backup
+// is only ever written once. Locking is passed through to Real.
 type BackupState struct {
-	Real State
-	Path string
+	Real         State
+	Path         string
+	Keep         int
+	ChecksumPath string
 
-	done bool
+	mu         sync.Mutex
+	done       bool
+	backupPath string
 }
 
 func (s *BackupState) State() *terraform.State {
@@ -20,27 +61,72 @@ func (s *BackupState) State() *terraform.State {
 }
 
 func (s *BackupState) RefreshState() error {
+	if s.ChecksumPath != "" {
+		if err := verifyChecksums(s.ChecksumPath); err != nil {
+			log.Printf("[WARN] state: %s", err)
+		}
+	}
+
 	return s.Real.RefreshState()
 }
 
 func (s *BackupState) WriteState(state *terraform.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if !s.done {
 		if err := s.backup(); err != nil {
 			return err
 		}
 	}
 
-	return s.Real.WriteState(state)
+	if err := s.Real.WriteState(state); err != nil {
+		return err
+	}
+
+	// A LocalState writes its file here rather than on PersistState
+	if _, ok := s.Real.(*LocalState); ok && s.ChecksumPath != "" {
+		return s.writeChecksums()
+	}
+
+	return nil
 }
 
 func (s *BackupState) PersistState() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if !s.done {
 		if err := s.backup(); err != nil {
 			return err
 		}
 	}
 
-	return s.Real.PersistState()
+	if err := s.Real.PersistState(); err != nil {
+		return err
+	}
+
+	if s.ChecksumPath != "" {
+		return s.writeChecksums()
+	}
+
+	return nil
+}
+
+func (s *BackupState) Lock(info *LockInfo) (string, error) {
+	return lockState(s.Real, info)
+}
+
+func (s *BackupState) Unlock(id string) error {
+	return unlockState(s.Real, id)
+}
+
+func (s *BackupState) LockInfo() (*LockInfo, error) {
+	return stateLockInfo(s.Real)
+}
+
+func (s *BackupState) ForceUnlock(id string) error {
+	return forceUnlockState(s.Real, id)
 }
 
 func (s *BackupState) backup() error {
@@ -53,11 +139,270 @@ func (s *BackupState) backup() error {
 		state = s.Real.State()
 	}
 
-	ls := &LocalState{Path: s.Path}
+	// Any failure from here on only affects the backup. Losing the real
+	// write because the backup couldn't be made is worse than going
+	// without a backup, so just warn about it. We don't retry later,
+	// since by then the state would no longer be the original one.
+	s.done = true
+
+	path := expandTimestampPlaceholders(s.Path, time.Now())
+	ls := &LocalState{Path: path}
 	if err := ls.WriteState(state); err != nil {
+		log.Printf("[WARN] state: failed to write backup to %s: %s", path, err)
+		return nil
+	}
+
+	s.backupPath = path
+
+	if s.Keep > 0 && path != s.Path {
+		s.removeOldBackups()
+	}
+
+	return nil
+}
+
+// removeOldBackups removes all but the newest Keep backups matching
+// Path. The backups are ordered by the timestamps in their names, which
+// don't sort as strings unless the placeholders run from the year down.
+// Failures are only logged.
+func (s *BackupState) removeOldBackups() {
+	matches, err := filepath.Glob(timestampGlob(s.Path))
+	if err != nil {
+		log.Printf("[WARN] state: failed to list old backups: %s", err)
+		return
+	}
+
+	re, fields := timestampRegexp(s.Path)
+	backups := make(backupsByAge, 0, len(matches))
+	for _, path := range matches {
+		t, ok := parseTimestamp(re, fields, path)
+		if !ok {
+			continue
+		}
+
+		backups = append(backups, timestampedBackup{Path: path, Time: t})
+	}
+
+	sort.Sort(backups)
+	for len(backups) > s.Keep {
+		old := backups[0].Path
+		backups = backups[1:]
+
+		log.Printf("[DEBUG] state: removing old backup %s", old)
+		if err := os.Remove(old); err != nil {
+			log.Printf("[WARN] state: failed to remove old backup %s: %s", old, err)
+		}
+	}
+}
+
+// timestampedBackup is a backup file and the time in its name.
+type timestampedBackup struct {
+	Path string
+	Time time.Time
+}
+
+// backupsByAge implements sort.Interface to sort backups oldest first.
+type backupsByAge []timestampedBackup
+
+func (s backupsByAge) Len() int {
+	return len(s)
+}
+
+func (s backupsByAge) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s backupsByAge) Less(i, j int) bool {
+	if !s[i].Time.Equal(s[j].Time) {
+		return s[i].Time.Before(s[j].Time)
+	}
+
+	return s[i].Path < s[j].Path
+}
+
+// writeChecksums records the checksums of the real state file and the
+// backup, if we have them as files, to ChecksumPath. A real state file
+// that doesn't exist, because the state was removed, is left out.
+func (s *BackupState) writeChecksums() error {
+	var paths []string
+	if ls, ok := s.Real.(*LocalState); ok {
+		paths = append(paths, ls.writePath())
+	}
+	if s.backupPath != "" {
+		paths = append(paths, s.backupPath)
+	}
+
+	sums := make(map[string]string, len(paths))
+	for _, path := range paths {
+		sum, err := fileChecksum(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error computing checksum of %s: %s", path, err)
+		}
+
+		sums[path] = sum
+	}
+
+	raw, err := json.MarshalIndent(sums, "", "    ")
+	if err != nil {
 		return err
 	}
 
-	s.done = true
+	if err := os.MkdirAll(filepath.Dir(s.ChecksumPath), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.ChecksumPath, raw, 0644)
+}
+
+// verifyChecksums checks the files recorded in the checksum file at path
+// against their recorded checksums, returning an error describing any
+// that don't match or can't be read. A missing checksum file is not an
+// error, since nothing has been recorded yet.
+func verifyChecksums(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	var sums map[string]string
+	if err := json.Unmarshal(raw, &sums); err != nil {
+		return fmt.Errorf("Error reading state checksums from %s: %s", path, err)
+	}
+
+	for file, expected := range sums {
+		actual, err := fileChecksum(file)
+		if err != nil {
+			return fmt.Errorf("Error verifying checksum of %s: %s", file, err)
+		}
+
+		if actual != expected {
+			return fmt.Errorf(
+				"checksum of %s doesn't match the one recorded in %s. The\n"+
+					"file may have been corrupted or modified outside of Terraform.",
+				file, path)
+		}
+	}
+
 	return nil
 }
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of the file at
+// path.
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expandTimestampPlaceholders replaces the strftime-style placeholders
+// %Y, %m, %d, %H, %M and %S in pattern with the corresponding parts of t
+// in UTC. Any other text, including unknown placeholders, is left as-is.
+func expandTimestampPlaceholders(pattern string, t time.Time) string {
+	if !strings.Contains(pattern, "%") {
+		return pattern
+	}
+
+	t = t.UTC()
+	r := strings.NewReplacer(
+		"%Y", fmt.Sprintf("%04d", t.Year()),
+		"%m", fmt.Sprintf("%02d", t.Month()),
+		"%d", fmt.Sprintf("%02d", t.Day()),
+		"%H", fmt.Sprintf("%02d", t.Hour()),
+		"%M", fmt.Sprintf("%02d", t.Minute()),
+		"%S", fmt.Sprintf("%02d", t.Second()),
+	)
+
+	return r.Replace(pattern)
+}
+
+// timestampGlob returns a filepath.Glob pattern matching the paths that
+// expandTimestampPlaceholders can produce from pattern.
+func timestampGlob(pattern string) string {
+	digits := func(n int) string {
+		return strings.Repeat("[0-9]", n)
+	}
+
+	r := strings.NewReplacer(
+		"*", "[*]",
+		"?", "[?]",
+		"[", "[[]",
+		"%Y", digits(4),
+		"%m", digits(2),
+		"%d", digits(2),
+		"%H", digits(2),
+		"%M", digits(2),
+		"%S", digits(2),
+	)
+
+	return r.Replace(pattern)
+}
+
+// timestampRegexp returns a regexp matching the paths that
+// expandTimestampPlaceholders can produce from pattern, with a group for
+// each placeholder, and the placeholder of each group in order.
+func timestampRegexp(pattern string) (*regexp.Regexp, []byte) {
+	var buf bytes.Buffer
+	var fields []byte
+	buf.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '%' && i+1 < len(pattern) {
+			switch f := pattern[i+1]; f {
+			case 'Y':
+				buf.WriteString(`(\d{4})`)
+				fields = append(fields, f)
+				i++
+				continue
+			case 'm', 'd', 'H', 'M', 'S':
+				buf.WriteString(`(\d{2})`)
+				fields = append(fields, f)
+				i++
+				continue
+			}
+		}
+
+		buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+	}
+	buf.WriteString("$")
+
+	return regexp.MustCompile(buf.String()), fields
+}
+
+// parseTimestamp returns the time that expandTimestampPlaceholders put in
+// path, using the regexp and fields from timestampRegexp. Parts of the
+// time without a placeholder are left at their lowest value.
+func parseTimestamp(re *regexp.Regexp, fields []byte, path string) (time.Time, bool) {
+	m := re.FindStringSubmatch(path)
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	parts := map[byte]int{'m': 1, 'd': 1}
+	for i, f := range fields {
+		v, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		parts[f] = v
+	}
+
+	return time.Date(
+		parts['Y'], time.Month(parts['m']), parts['d'],
+		parts['H'], parts['M'], parts['S'], 0, time.UTC), true
+}
@@ -1,9 +1,16 @@
 package state
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
 )
 
 func TestBackupState(t *testing.T) {
@@ -27,3 +34,371 @@ func TestBackupState(t *testing.T) {
 		t.Fatalf("bad: %d", fi.Size())
 	}
 }
+
+func TestBackupState_impl(t *testing.T) {
+	var _ StateReader = new(BackupState)
+	var _ StateWriter = new(BackupState)
+	var _ StatePersister = new(BackupState)
+	var _ StateRefresher = new(BackupState)
+	var _ ForceUnlocker = new(BackupState)
+}
+
+func TestBackupState_lock(t *testing.T) {
+	real := &InmemState{state: TestStateInitial()}
+	bs := &BackupState{Real: real, Path: "unused"}
+
+	id, err := bs.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info, err := real.LockInfo(); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if info == nil || info.ID != id {
+		t.Fatalf("lock should be passed to the real state: %v", info)
+	}
+
+	if err := bs.ForceUnlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info, _ := bs.LockInfo(); info != nil {
+		t.Fatalf("should be unlocked: %s", info)
+	}
+}
+
+func TestBackupState_concurrentPersist(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	bs := &BackupState{
+		Real: ls,
+		Path: f.Name(),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bs.PersistState(); err != nil {
+				t.Errorf("err: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	backup, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer backup.Close()
+
+	actual, err := terraform.ReadState(backup)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !actual.Equal(TestStateInitial()) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackupState_backupError(t *testing.T) {
+	// A directory can't be written as a file, so only the backup fails.
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	bs := &BackupState{Real: ls, Path: dir}
+	if err := bs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	state := bs.State()
+	state.Serial++
+	if err := bs.WriteState(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := bs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := &LocalState{Path: ls.Path}
+	if err := actual.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual.State().Serial != state.Serial {
+		t.Fatalf("bad serial: %d", actual.State().Serial)
+	}
+}
+
+func TestBackupState_checksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	bs := &BackupState{
+		Real:         ls,
+		Path:         filepath.Join(dir, "backup.tfstate"),
+		ChecksumPath: filepath.Join(dir, ".terraform", "state_checksums.json"),
+	}
+	TestState(t, bs)
+
+	if _, err := os.Stat(bs.ChecksumPath); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := bs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Simulate corruption of the real state file
+	f, err := os.OpenFile(ls.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, err = f.WriteString("\n")
+	f.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// That is reported, but doesn't stop the state being read, since
+	// another run of Terraform may have written it.
+	if err := verifyChecksums(bs.ChecksumPath); err == nil {
+		t.Fatal("should report a mismatch")
+	}
+	if err := bs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackupState_checksumsWriteState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	bs := &BackupState{
+		Real:         ls,
+		Path:         filepath.Join(dir, "backup.tfstate"),
+		ChecksumPath: filepath.Join(dir, "state_checksums.json"),
+	}
+	if err := bs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := bs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A LocalState writes its file on WriteState, so the checksums must
+	// be kept up to date there too, without a PersistState.
+	state := bs.State()
+	state.Serial++
+	if err := bs.WriteState(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := verifyChecksums(bs.ChecksumPath); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Removing the state is reported, but doesn't stop a refresh
+	if err := os.Remove(ls.Path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := verifyChecksums(bs.ChecksumPath); err == nil {
+		t.Fatal("should report the missing file")
+	}
+	if err := bs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackupState_timestampPath(t *testing.T) {
+	td, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	bs := &BackupState{
+		Real: ls,
+		Path: filepath.Join(td, "terraform.tfstate.%Y.backup"),
+	}
+	if err := bs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := filepath.Join(td, fmt.Sprintf(
+		"terraform.tfstate.%d.backup", time.Now().UTC().Year()))
+	if _, err := os.Stat(expected); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestBackupState_keep(t *testing.T) {
+	td, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	// Older backups, plus an unrelated file that must be left alone
+	for _, name := range []string{
+		"terraform.tfstate.20000101000000.backup",
+		"terraform.tfstate.20000102000000.backup",
+		"terraform.tfstate.20000103000000.backup",
+		"terraform.tfstate.old.backup",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(td, name), nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	bs := &BackupState{
+		Real: ls,
+		Path: filepath.Join(td, "terraform.tfstate.%Y%m%d%H%M%S.backup"),
+		Keep: 2,
+	}
+	if err := bs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(td)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var actual []string
+	for _, f := range files {
+		actual = append(actual, f.Name())
+	}
+
+	expected := []string{
+		"terraform.tfstate.20000103000000.backup",
+		filepath.Base(bs.backupPath),
+		"terraform.tfstate.old.backup",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestBackupState_keepDayFirst(t *testing.T) {
+	td, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	// With the day first, the names don't sort by age: 2 January sorts
+	// after 1 February.
+	for _, name := range []string{
+		"terraform.tfstate.02-01-2000-000000.backup",
+		"terraform.tfstate.01-02-2000-000000.backup",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(td, name), nil, 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	bs := &BackupState{
+		Real: ls,
+		Path: filepath.Join(td, "terraform.tfstate.%d-%m-%Y-%H%M%S.backup"),
+		Keep: 2,
+	}
+	if err := bs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	files, err := ioutil.ReadDir(td)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	actual := make(map[string]bool)
+	for _, f := range files {
+		actual[f.Name()] = true
+	}
+
+	expected := map[string]bool{
+		"terraform.tfstate.01-02-2000-000000.backup": true,
+		filepath.Base(bs.backupPath):                 true,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestExpandTimestampPlaceholders(t *testing.T) {
+	now := time.Date(2017, time.January, 2, 3, 4, 5, 0, time.UTC)
+	est := time.FixedZone("EST", -5*60*60)
+
+	cases := []struct {
+		Pattern  string
+		Time     time.Time
+		Expected string
+	}{
+		{
+			"terraform.tfstate.backup",
+			now,
+			"terraform.tfstate.backup",
+		},
+		{
+			"terraform.tfstate.%Y%m%d%H%M%S.backup",
+			now,
+			"terraform.tfstate.20170102030405.backup",
+		},
+		{
+			"backups/%Y/%m/%d/%H-%M-%S.tfstate",
+			now,
+			"backups/2017/01/02/03-04-05.tfstate",
+		},
+		{
+			"%Y-%Y",
+			now,
+			"2017-2017",
+		},
+		{
+			// Always expanded in UTC
+			"%Y%m%d%H",
+			time.Date(2017, time.January, 1, 22, 0, 0, 0, est),
+			"2017010203",
+		},
+		{
+			// Unknown placeholders are left alone
+			"100%-%x-%Y",
+			now,
+			"100%-%x-2017",
+		},
+	}
+
+	for i, tc := range cases {
+		actual := expandTimestampPlaceholders(tc.Pattern, tc.Time)
+		if actual != tc.Expected {
+			t.Fatalf("%d: %q: expected %q, got %q", i, tc.Pattern, tc.Expected, actual)
+		}
+	}
+}
@@ -12,8 +12,19 @@ type CacheState struct {
 	Cache   CacheStateCache
 	Durable CacheStateDurable
 
+	// CheckDurable, if set, makes PersistState refresh the durable state
+	// first and fail with a CacheConflictError, rather than overwrite it,
+	// if it has changed since this CacheState last refreshed or persisted
+	// it. This costs a round trip to the durable storage on every persist.
+	CheckDurable bool
+
 	refreshResult CacheRefreshResult
 	state         *terraform.State
+
+	// durableSeen is a copy of the durable state as of the last refresh
+	// or successful persist. The state being persisted can't be compared
+	// instead, since Terraform changes it in place between persists.
+	durableSeen *terraform.State
 }
 
 // StateReader impl.
@@ -56,6 +67,7 @@ func (s *CacheState) RefreshState() error {
 	// two states.
 	cached := s.Cache.State()
 	durable := s.Durable.State()
+	s.durableSeen = durable.DeepCopy()
 	switch {
 	case cached == nil && durable == nil:
 		// Initialized
@@ -127,13 +139,111 @@ func (s *CacheState) RefreshResult() CacheRefreshResult {
 // assumption that the local state is the latest, call a RefreshState prior
 // to this.
 //
+// If CheckDurable is set, the durable state is checked for changes made
+// since it was last refreshed before it is replaced.
+//
 // StatePersister impl.
 func (s *CacheState) PersistState() error {
+	if s.CheckDurable {
+		if err := s.checkDurable(); err != nil {
+			return err
+		}
+	}
+
 	if err := s.Durable.WriteState(s.state); err != nil {
 		return err
 	}
+	if err := s.Durable.PersistState(); err != nil {
+		return err
+	}
+
+	s.durableSeen = s.Durable.State().DeepCopy()
+	return nil
+}
+
+// checkDurable refreshes the durable state and returns a
+// CacheConflictError if it has been changed by something else since it
+// was last refreshed or persisted, so persisting would lose that change.
+func (s *CacheState) checkDurable() error {
+	if err := s.Durable.RefreshState(); err != nil {
+		return err
+	}
+
+	durable := s.Durable.State()
+	seen := s.durableSeen
+	if durable == nil || s.state == nil {
+		return nil
+	}
+
+	// If we've never seen the durable state, the best we can do is make
+	// sure we're not about to replace a newer one.
+	if seen == nil {
+		seen = s.state
+		if durable.Serial <= seen.Serial {
+			return nil
+		}
+	}
+
+	switch {
+	case durable.Serial == seen.Serial && durable.Equal(seen):
+		return nil
+	case durable.Serial > seen.Serial:
+		s.refreshResult = CacheRefreshRemoteNewer
+	default:
+		s.refreshResult = CacheRefreshConflict
+	}
+
+	return &CacheConflictError{
+		RemoteConflictError: RemoteConflictError{
+			Reason: s.refreshResult.String(),
+		},
+		Result:        s.refreshResult,
+		DurableSerial: durable.Serial,
+		Serial:        seen.Serial,
+	}
+}
+
+// CacheConflictError is returned by CacheState.PersistState when the
+// durable state has changed in a way that persisting the cached state
+// would lose.
+type CacheConflictError struct {
+	// RemoteConflictError has the Result as its Reason.
+	RemoteConflictError
+
+	Result CacheRefreshResult
+
+	// DurableSerial is the serial of the durable state now, and Serial
+	// is the serial it had when it was last refreshed or persisted.
+	DurableSerial int64
+	Serial        int64
+}
+
+func (e *CacheConflictError) Error() string {
+	return fmt.Sprintf(
+		"%s: remote state has serial %d, expected serial %d",
+		e.Result, e.DurableSerial, e.Serial)
+}
+
+// Lock locks the durable state.
+//
+// Locker impl.
+func (s *CacheState) Lock(info *LockInfo) (string, error) {
+	return lockState(s.Durable, info)
+}
+
+// Locker impl.
+func (s *CacheState) Unlock(id string) error {
+	return unlockState(s.Durable, id)
+}
+
+// ForceUnlocker impl.
+func (s *CacheState) LockInfo() (*LockInfo, error) {
+	return stateLockInfo(s.Durable)
+}
 
-	return s.Durable.PersistState()
+// ForceUnlocker impl.
+func (s *CacheState) ForceUnlock(id string) error {
+	return forceUnlockState(s.Durable, id)
 }
 
 // CacheStateCache is the meta-interface that must be implemented for
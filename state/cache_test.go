@@ -53,6 +53,101 @@ func TestCacheState_persistDurable(t *testing.T) {
 	}
 }
 
+func TestCacheState_checkDurable(t *testing.T) {
+	cache := testLocalState(t)
+	defer os.Remove(cache.Path)
+	durable := &InmemState{}
+
+	cs := &CacheState{
+		Cache:        cache,
+		Durable:      durable,
+		CheckDurable: true,
+	}
+	if err := cs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Nothing has changed the durable state, so this persists
+	if err := cs.PersistState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Something else writes a newer state
+	newer := durable.State()
+	newer.Serial += 5
+	if err := durable.WriteState(newer); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := cs.PersistState()
+	cerr, ok := err.(*CacheConflictError)
+	if !ok {
+		t.Fatalf("expected CacheConflictError, got %v", err)
+	}
+	if cerr.Result != CacheRefreshRemoteNewer {
+		t.Fatalf("bad: %v", cerr.Result)
+	}
+	if cs.RefreshResult() != CacheRefreshRemoteNewer {
+		t.Fatalf("bad: %v", cs.RefreshResult())
+	}
+	if durable.State().Serial != newer.Serial {
+		t.Fatal("durable state should not have been overwritten")
+	}
+}
+
+func TestCacheState_checkDurablePersistTwice(t *testing.T) {
+	cache := testLocalState(t)
+	durable := testLocalState(t)
+	defer os.Remove(cache.Path)
+	defer os.Remove(durable.Path)
+
+	cs := &CacheState{
+		Cache:        cache,
+		Durable:      durable,
+		CheckDurable: true,
+	}
+	if err := cs.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Terraform updates the same state object in place as it applies and
+	// persists it periodically, so do the same here.
+	state := cs.State()
+	for i := 0; i < 3; i++ {
+		state.RootModule().Outputs["count"] = &terraform.OutputState{
+			Type:  "string",
+			Value: fmt.Sprintf("%d", i),
+		}
+		if err := cs.WriteState(state); err != nil {
+			t.Fatalf("%d: err: %s", i, err)
+		}
+		if err := cs.PersistState(); err != nil {
+			t.Fatalf("%d: err: %s", i, err)
+		}
+	}
+
+	if err := durable.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	actual := durable.State().RootModule().Outputs["count"]
+	if actual == nil || actual.Value != "2" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	// A change made by something else since our last persist is still
+	// caught.
+	other := durable.State()
+	other.RootModule().Outputs["count"].Value = "other"
+	if err := durable.WriteState(other); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := cs.PersistState()
+	if _, ok := err.(*CacheConflictError); !ok {
+		t.Fatalf("expected CacheConflictError, got %v", err)
+	}
+}
+
 func TestCacheState_RefreshState(t *testing.T) {
 	for i, test := range []struct {
 		cacheModules []*terraform.ModuleState
@@ -115,4 +210,5 @@ func TestCacheState_impl(t *testing.T) {
 	var _ StateWriter = new(CacheState)
 	var _ StatePersister = new(CacheState)
 	var _ StateRefresher = new(CacheState)
+	var _ ForceUnlocker = new(CacheState)
 }
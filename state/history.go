@@ -0,0 +1,229 @@
+package state
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// historyTimeFormat is the format of the time in history snapshot names.
+const historyTimeFormat = "20060102T150405Z"
+
+// HistoryState wraps a State and, after every successful PersistState,
+// writes a snapshot of the persisted state to Dir, named
+// "<serial>-<timestamp>.tfstate". Unlike BackupState, which keeps the
+// state from before a run, this keeps every state that was saved, so an
+// earlier one can be found with ListHistory and brought back with
+// RestoreHistory.
+//
+// If Keep is greater than zero, only the newest Keep snapshots are kept.
+//
+// As with backups, failing to write a snapshot doesn't fail the
+// PersistState: a warning is logged instead.
+type HistoryState struct {
+	Real State
+	Dir  string
+	Keep int
+}
+
+// StateReader impl.
+func (s *HistoryState) State() *terraform.State {
+	return s.Real.State()
+}
+
+// StateRefresher impl.
+func (s *HistoryState) RefreshState() error {
+	return s.Real.RefreshState()
+}
+
+// StateWriter impl.
+func (s *HistoryState) WriteState(state *terraform.State) error {
+	return s.Real.WriteState(state)
+}
+
+// StatePersister impl.
+func (s *HistoryState) PersistState() error {
+	if err := s.Real.PersistState(); err != nil {
+		return err
+	}
+
+	if err := s.snapshot(); err != nil {
+		log.Printf("[WARN] state: failed to write history snapshot to %s: %s", s.Dir, err)
+	}
+
+	return nil
+}
+
+// Locker impl.
+func (s *HistoryState) Lock(info *LockInfo) (string, error) {
+	return lockState(s.Real, info)
+}
+
+// Locker impl.
+func (s *HistoryState) Unlock(id string) error {
+	return unlockState(s.Real, id)
+}
+
+// ForceUnlocker impl.
+func (s *HistoryState) LockInfo() (*LockInfo, error) {
+	return stateLockInfo(s.Real)
+}
+
+// ForceUnlocker impl.
+func (s *HistoryState) ForceUnlock(id string) error {
+	return forceUnlockState(s.Real, id)
+}
+
+func (s *HistoryState) snapshot() error {
+	state := s.Real.State()
+	if state == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.tfstate",
+		state.Serial, time.Now().UTC().Format(historyTimeFormat))
+	err := writeFileAtomic(filepath.Join(s.Dir, name), func(w io.Writer) error {
+		return terraform.WriteState(state, w)
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.Keep <= 0 {
+		return nil
+	}
+
+	entries, err := ListHistory(s.Dir)
+	if err != nil {
+		return err
+	}
+	for len(entries) > s.Keep {
+		if err := os.Remove(entries[0].Path); err != nil {
+			return err
+		}
+		entries = entries[1:]
+	}
+
+	return nil
+}
+
+// HistoryEntry is a state snapshot written by HistoryState.
+type HistoryEntry struct {
+	Serial int64
+	Time   time.Time
+	Path   string
+}
+
+// ListHistory returns the snapshots in the history directory dir, oldest
+// first. Files in dir that aren't snapshots are ignored. If dir doesn't
+// exist, the history is empty.
+func ListHistory(dir string) ([]*HistoryEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var result []*HistoryEntry
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".tfstate") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, ".tfstate"), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		serial, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		t, err := time.Parse(historyTimeFormat, parts[1])
+		if err != nil {
+			continue
+		}
+
+		result = append(result, &HistoryEntry{
+			Serial: serial,
+			Time:   t,
+			Path:   filepath.Join(dir, name),
+		})
+	}
+
+	sort.Sort(historyEntrySort(result))
+	return result, nil
+}
+
+// RestoreHistory replaces the state in s with the newest snapshot in dir
+// that has the given serial, and persists it. The restored state is given
+// a new serial above the current one, like any other change.
+func RestoreHistory(s State, dir string, serial int64) error {
+	entries, err := ListHistory(dir)
+	if err != nil {
+		return err
+	}
+
+	var entry *HistoryEntry
+	for _, e := range entries {
+		if e.Serial == serial {
+			entry = e
+		}
+	}
+	if entry == nil {
+		return fmt.Errorf("no state with serial %d in the history at %s", serial, dir)
+	}
+
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	state, err := terraform.ReadState(f)
+	if err != nil {
+		return fmt.Errorf("Error reading state history %s: %s", entry.Path, err)
+	}
+
+	if err := s.RefreshState(); err != nil {
+		return err
+	}
+	if current := s.State(); current != nil && current.Serial >= state.Serial {
+		state.Serial = current.Serial + 1
+	}
+	if err := s.WriteState(state); err != nil {
+		return err
+	}
+
+	return s.PersistState()
+}
+
+// historyEntrySort implements sort.Interface to sort history entries by
+// serial and then time.
+type historyEntrySort []*HistoryEntry
+
+func (l historyEntrySort) Len() int      { return len(l) }
+func (l historyEntrySort) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l historyEntrySort) Less(i, j int) bool {
+	if l[i].Serial != l[j].Serial {
+		return l[i].Serial < l[j].Serial
+	}
+
+	return l[i].Time.Before(l[j].Time)
+}
@@ -0,0 +1,95 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryState(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	TestState(t, &HistoryState{
+		Real: ls,
+		Dir:  dir,
+	})
+	if entries, err := ListHistory(dir); err != nil || len(entries) == 0 {
+		t.Fatalf("expected snapshots, got %d: %v", len(entries), err)
+	}
+}
+
+func TestHistoryState_impl(t *testing.T) {
+	var _ StateReader = new(HistoryState)
+	var _ StateWriter = new(HistoryState)
+	var _ StatePersister = new(HistoryState)
+	var _ StateRefresher = new(HistoryState)
+	var _ ForceUnlocker = new(HistoryState)
+}
+
+func TestHistoryState_keepAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	historyDir := filepath.Join(dir, "history")
+	hs := &HistoryState{
+		Real: &InmemState{},
+		Dir:  historyDir,
+		Keep: 2,
+	}
+
+	// Persist three different states
+	for i := 0; i < 3; i++ {
+		state := TestStateInitial()
+		state.Serial = int64(i + 1)
+		if err := hs.WriteState(state); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := hs.PersistState(); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	entries, err := ListHistory(historyDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(entries))
+	}
+	if entries[0].Serial != 2 || entries[1].Serial != 3 {
+		t.Fatalf("bad: %d, %d", entries[0].Serial, entries[1].Serial)
+	}
+
+	// Restore the oldest snapshot kept
+	if err := RestoreHistory(hs, historyDir, 2); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual := hs.State(); actual.Serial != 4 {
+		t.Fatalf("restored state should have a new serial, got %d", actual.Serial)
+	}
+
+	if err := RestoreHistory(hs, historyDir, 1); err == nil {
+		t.Fatal("expected error restoring a serial that isn't kept")
+	}
+}
+
+func TestListHistory_missing(t *testing.T) {
+	entries, err := ListHistory(filepath.Join(os.TempDir(), "tf-does-not-exist"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("bad: %#v", entries)
+	}
+}
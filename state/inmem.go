@@ -1,12 +1,20 @@
 package state
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/satori/go.uuid"
 )
 
 // InmemState is an in-memory state storage.
 type InmemState struct {
 	state *terraform.State
+
+	// mu guards lock, the info of the lock currently held, if any.
+	mu   sync.Mutex
+	lock *LockInfo
 }
 
 func (s *InmemState) State() *terraform.State {
@@ -26,3 +34,54 @@ func (s *InmemState) WriteState(state *terraform.State) error {
 func (s *InmemState) PersistState() error {
 	return nil
 }
+
+// Lock implements Locker. The lock only guards against other users of
+// the same InmemState, which is enough for tests and throwaway runs.
+func (s *InmemState) Lock(info *LockInfo) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock != nil {
+		return "", &LockError{Info: s.lock}
+	}
+
+	if info == nil {
+		info = NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+
+	s.lock = info
+	return info.ID, nil
+}
+
+// Unlock implements Locker.
+func (s *InmemState) Unlock(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lock == nil {
+		return fmt.Errorf("state is not locked")
+	}
+	if s.lock.ID != id {
+		return fmt.Errorf("lock ID %q does not match the lock on the state", id)
+	}
+
+	s.lock = nil
+	return nil
+}
+
+// LockInfo implements ForceUnlocker.
+func (s *InmemState) LockInfo() (*LockInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lock, nil
+}
+
+// ForceUnlock implements ForceUnlocker. Every user of an InmemState is in
+// this process, so this is the same as Unlock.
+func (s *InmemState) ForceUnlock(id string) error {
+	return s.Unlock(id)
+}
@@ -13,4 +13,33 @@ func TestInmemState_impl(t *testing.T) {
 	var _ StateWriter = new(InmemState)
 	var _ StatePersister = new(InmemState)
 	var _ StateRefresher = new(InmemState)
+	var _ ForceUnlocker = new(InmemState)
+}
+
+func TestInmemState_lock(t *testing.T) {
+	s := &InmemState{state: TestStateInitial()}
+
+	id, err := s.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = s.Lock(NewLockInfo())
+	lockErr, ok := err.(*LockError)
+	if !ok {
+		t.Fatalf("expected LockError, got %v", err)
+	}
+	if lockErr.Info == nil || lockErr.Info.ID != id {
+		t.Fatalf("bad: %#v", lockErr.Info)
+	}
+	if err := s.Unlock("wrong"); err == nil {
+		t.Fatal("expected error unlocking with the wrong ID")
+	}
+
+	if err := s.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := s.Lock(NewLockInfo()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 }
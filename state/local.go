@@ -1,10 +1,24 @@
 package state
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/satori/go.uuid"
 )
 
 // LocalState manages a state storage that is local to the filesystem.
@@ -15,9 +29,54 @@ type LocalState struct {
 	Path    string
 	PathOut string
 
-	state     *terraform.State
-	readState *terraform.State
-	written   bool
+	// RequireExistingState, if set, makes RefreshState return an error
+	// when the state file doesn't exist, rather than starting from an
+	// empty state. This guards read-only flows against a misconfigured
+	// path silently reporting that there is no state.
+	RequireExistingState bool
+
+	// Compressed, if set, gzips the state when it is written. Reading
+	// detects gzipped state by its magic bytes regardless of this setting
+	// or the file extension.
+	Compressed bool
+
+	// RegenerateLineage, if set, makes RefreshState replace a lineage that
+	// isn't a UUID with a new one and log a warning, rather than returning
+	// a LineageFormatError.
+	RegenerateLineage bool
+
+	// SkipUnchangedWrites, if set, makes WriteState skip writing to disk
+	// when the state has the same serial and contents as the state this
+	// LocalState last wrote. This avoids churn when the same state is
+	// written repeatedly, such as by tools running in a loop.
+	SkipUnchangedWrites bool
+
+	// CheckStoredState, if set, makes WriteState compare the state with
+	// the state currently stored at the output path, and fail with a
+	// StateConflictError if the stored state has a different lineage or a
+	// newer serial. This catches the stored state having been changed by
+	// something else since it was read. Leave it unset to force the write.
+	CheckStoredState bool
+
+	// Warnings is set by RefreshState to warnings about the state file
+	// that didn't stop it from being read, such as top-level keys that
+	// this version of Terraform doesn't know about and so discards.
+	Warnings []string
+
+	// PollInterval is how often Watch checks the state file for changes.
+	// If zero, DefaultWatchPollInterval is used.
+	PollInterval time.Duration
+
+	state        *terraform.State
+	readState    *terraform.State
+	writtenState *terraform.State
+	raw          []byte
+	written      bool
+
+	// lockFile is the open, locked lock info file while we hold the
+	// lock, and lockID is the ID the lock was taken with.
+	lockFile *os.File
+	lockID   string
 }
 
 // SetState will force a specific state in-memory for this local state.
@@ -37,13 +96,11 @@ func (s *LocalState) State() *terraform.State {
 func (s *LocalState) WriteState(state *terraform.State) error {
 	s.state = state
 
-	path := s.PathOut
-	if path == "" {
-		path = s.Path
-	}
+	path := s.writePath()
 
 	// If we don't have any state, we actually delete the file if it exists
 	if state == nil {
+		s.writtenState = nil
 		err := os.Remove(path)
 		if err != nil && os.IsNotExist(err) {
 			return nil
@@ -52,24 +109,48 @@ func (s *LocalState) WriteState(state *terraform.State) error {
 		return err
 	}
 
-	// Create all the directories
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return err
+	if s.SkipUnchangedWrites && s.unchanged(state) {
+		log.Printf("[DEBUG] state: %s is unchanged, skipping write", path)
+		s.readState = state
+		return nil
 	}
 
-	f, err := os.Create(path)
-	if err != nil {
+	if s.CheckStoredState {
+		if err := checkStoredState(path, state); err != nil {
+			return err
+		}
+	}
+
+	// Create all the directories
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
-	defer f.Close()
 
 	s.state.IncrementSerialMaybe(s.readState)
 	s.readState = s.state
+	s.raw = nil
+	s.writtenState = nil
 
-	if err := terraform.WriteState(s.state, f); err != nil {
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		if !s.Compressed {
+			return terraform.WriteState(s.state, w)
+		}
+
+		gz := gzip.NewWriter(w)
+		if err := terraform.WriteState(s.state, gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+	if err != nil {
 		return err
 	}
 
+	// Keep a copy since the caller may go on to modify the state
+	if s.SkipUnchangedWrites {
+		s.writtenState = s.state.DeepCopy()
+	}
+
 	s.written = true
 	return nil
 }
@@ -83,33 +164,615 @@ func (s *LocalState) PersistState() error {
 
 // StateRefresher impl.
 func (s *LocalState) RefreshState() error {
-	// If we've never loaded before, read from Path, otherwise we
-	// read from PathOut.
-	path := s.Path
-	if s.written && s.PathOut != "" {
-		path = s.PathOut
-	}
+	s.Warnings = nil
 
-	f, err := os.Open(path)
+	path := s.readPath()
+	raw, err := readStateBytes(path)
 	if err != nil {
 		// It is okay if the file doesn't exist, we treat that as a nil state
 		if !os.IsNotExist(err) {
 			return err
 		}
 
-		f = nil
+		if s.RequireExistingState {
+			return fmt.Errorf("state file not found at %s", path)
+		}
+
+		raw = nil
 	}
 
 	var state *terraform.State
-	if f != nil {
-		defer f.Close()
-		state, err = terraform.ReadState(f)
+	if raw != nil {
+		state, err = terraform.ReadState(bytes.NewReader(raw))
 		if err != nil {
-			return err
+			return parseError(path, raw, err)
+		}
+
+		for _, k := range unknownStateKeys(raw) {
+			w := fmt.Sprintf(
+				"state file %s has unknown key %q, which will be discarded. It "+
+					"may have been written by a newer version of Terraform.",
+				path, k)
+			log.Printf("[WARN] state: %s", w)
+			s.Warnings = append(s.Warnings, w)
+		}
+
+		if state.Lineage != "" && !lineageRegexp.MatchString(state.Lineage) {
+			if !s.RegenerateLineage {
+				return &LineageFormatError{Path: path, Lineage: state.Lineage}
+			}
+
+			log.Printf(
+				"[WARN] state: replacing invalid lineage %q in %s",
+				state.Lineage, path)
+			state.Lineage = ""
+			state.EnsureHasLineage()
 		}
 	}
 
 	s.state = state
 	s.readState = state
+	s.writtenState = nil
+	s.raw = raw
 	return nil
 }
+
+// CompactState reads the state file, removes data that no longer has any
+// effect as described by terraform.State.Compact, and writes it back
+// with an incremented serial. If there is no state, nothing is written.
+func (s *LocalState) CompactState() error {
+	if err := s.RefreshState(); err != nil {
+		return err
+	}
+
+	state := s.State()
+	if state == nil {
+		return nil
+	}
+
+	state.Compact()
+	state.IncrementSerial()
+	return s.WriteState(state)
+}
+
+// PartialLoad reads only the given module subtrees from the state file,
+// skipping the decoding of all other modules. Each filter entry is a
+// module address such as "module.foo.module.bar" and selects that module
+// and its descendants; an empty entry selects the root module and so the
+// whole state. Only the current state format version is supported.
+//
+// The root module is always present in the result, but is empty unless
+// the filter selects it. The partial state is returned but not kept by
+// the LocalState, so it can't accidentally be written back over the full
+// state. If the state file doesn't exist, nil is returned.
+func (s *LocalState) PartialLoad(filter []string) (*terraform.State, error) {
+	path := s.readPath()
+	raw, err := readStateBytes(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if s.RequireExistingState {
+			return nil, fmt.Errorf("state file not found at %s", path)
+		}
+
+		return nil, nil
+	}
+
+	prefixes := make([][]string, len(filter))
+	for i, f := range filter {
+		prefixes[i] = append([]string{"root"}, terraform.ParseResourcePath(f)...)
+	}
+
+	scanner := NewStateScanner(bytes.NewReader(raw))
+	return scanner.State(func(p []string) bool {
+		for _, prefix := range prefixes {
+			if len(p) >= len(prefix) && reflect.DeepEqual(p[:len(prefix)], prefix) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// RawBytes returns the JSON bytes of the state file, decompressed if the
+// file is gzipped, for tools that do their own processing of the state.
+// The bytes cached by the last RefreshState are returned if there are
+// any, otherwise the file is read fresh.
+func (s *LocalState) RawBytes() ([]byte, error) {
+	if s.raw != nil {
+		result := make([]byte, len(s.raw))
+		copy(result, s.raw)
+		return result, nil
+	}
+
+	return readStateBytes(s.readPath())
+}
+
+// unchanged returns true if state is the same as the state we last
+// wrote. State.Equal only compares versions and modules, so the other
+// top-level fields are compared too.
+func (s *LocalState) unchanged(state *terraform.State) bool {
+	last := s.writtenState
+	if last == nil {
+		return false
+	}
+
+	return state.Serial == last.Serial &&
+		state.Lineage == last.Lineage &&
+		state.TFVersion == last.TFVersion &&
+		reflect.DeepEqual(state.Remote, last.Remote) &&
+		state.Equal(last)
+}
+
+// Lock locks the state using an OS-level lock on a hidden lock info file
+// next to the state file, and writes info into it. The lock is held
+// until Unlock is called or the process exits. If the state is already
+// locked, the error includes the lock info of the current holder if it
+// can be read.
+//
+// Locker impl.
+func (s *LocalState) Lock(info *LockInfo) (string, error) {
+	if s.lockFile != nil {
+		return "", fmt.Errorf("state %s is already locked", s.Path)
+	}
+
+	if info == nil {
+		info = NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = s.Path
+
+	path := s.lockInfoPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+
+		return "", &LockError{Path: s.Path, Info: readLockInfo(path), Err: err}
+	}
+
+	raw, err := json.MarshalIndent(info, "", "  ")
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt(raw, 0)
+	}
+	if err != nil {
+		unlockFile(f)
+		f.Close()
+		return "", fmt.Errorf("Error writing lock info for %s: %s", s.Path, err)
+	}
+
+	s.lockFile = f
+	s.lockID = info.ID
+	return info.ID, nil
+}
+
+// Unlock releases the lock taken by Lock. id must be the ID that Lock
+// returned.
+//
+// Locker impl.
+func (s *LocalState) Unlock(id string) error {
+	if s.lockFile == nil {
+		return fmt.Errorf("state %s is not locked", s.Path)
+	}
+	if id != s.lockID {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s", id, s.Path)
+	}
+
+	// Clear the lock info while we still hold the lock, so we can't clear
+	// someone else's. The file itself is left in place: if it were
+	// removed, a process waiting on the old file and one creating a new
+	// file at the same path could both take "the" lock.
+	truncErr := s.lockFile.Truncate(0)
+
+	err := unlockFile(s.lockFile)
+	s.lockFile.Close()
+	s.lockFile = nil
+	s.lockID = ""
+
+	if err == nil && truncErr != nil {
+		err = fmt.Errorf("Error clearing lock info for %s: %s", s.Path, truncErr)
+	}
+
+	return err
+}
+
+// LockInfo returns the info of the lock on the state if any process,
+// including this one, holds it.
+//
+// ForceUnlocker impl.
+func (s *LocalState) LockInfo() (*LockInfo, error) {
+	path := s.lockInfoPath()
+	if s.lockFile != nil {
+		return readLockInfo(path), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+	defer f.Close()
+
+	// If we can take the lock no one holds it, however stale the info
+	// file left behind is.
+	if err := lockFile(f); err == nil {
+		unlockFile(f)
+		return nil, nil
+	}
+
+	info := readLockInfo(path)
+	if info == nil {
+		return nil, fmt.Errorf("state %s is locked, but its lock info can't be read", s.Path)
+	}
+
+	return info, nil
+}
+
+// ForceUnlock releases the lock if this LocalState holds it. The OS
+// releases the lock of a process that exits, so a local state can't be
+// left locked by a crashed run, and a lock held by another running
+// process can't be removed.
+//
+// ForceUnlocker impl.
+func (s *LocalState) ForceUnlock(id string) error {
+	if s.lockFile != nil {
+		return s.Unlock(id)
+	}
+
+	info, err := s.LockInfo()
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("state %s is not locked", s.Path)
+	}
+	if info.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s", id, s.Path)
+	}
+
+	return fmt.Errorf(
+		"state %s is locked by a running process: %s\n\n"+
+			"Local state locks are released when the process holding them\n"+
+			"exits, so stop that process instead.",
+		s.Path, info.Who)
+}
+
+// lockInfoPath returns the path of the lock info file for the state,
+// such as ".terraform.tfstate.lock.info" next to "terraform.tfstate".
+func (s *LocalState) lockInfoPath() string {
+	dir, file := filepath.Split(s.Path)
+	return filepath.Join(dir, fmt.Sprintf(".%s.lock.info", file))
+}
+
+// readLockInfo reads the lock info file at path, returning nil if it
+// can't be read.
+func readLockInfo(path string) *LockInfo {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	info := new(LockInfo)
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil
+	}
+
+	return info
+}
+
+// MarshalJSON returns a short JSON summary of the local state, with the
+// path of the state file, the serial and lineage of the state in memory
+// and whether we hold its lock. It is meant for including in logs and
+// diagnostics, and is not the state file format. Use terraform.WriteState
+// for that.
+func (s *LocalState) MarshalJSON() ([]byte, error) {
+	summary := struct {
+		Path    string `json:"path"`
+		Serial  int64  `json:"serial"`
+		Lineage string `json:"lineage"`
+		Locked  bool   `json:"locked"`
+	}{
+		Path:   s.readPath(),
+		Locked: s.lockFile != nil,
+	}
+	if s.state != nil {
+		summary.Serial = s.state.Serial
+		summary.Lineage = s.state.Lineage
+	}
+
+	return json.Marshal(summary)
+}
+
+// readPath returns the path that the state is read from. If we've never
+// written before this is Path, otherwise it is PathOut.
+func (s *LocalState) readPath() string {
+	if s.written && s.PathOut != "" {
+		return s.PathOut
+	}
+
+	return s.Path
+}
+
+// writePath returns the path that the state is written to.
+func (s *LocalState) writePath() string {
+	if s.PathOut != "" {
+		return s.PathOut
+	}
+
+	return s.Path
+}
+
+// readStateBytes reads the state file at path, decompressing it if it
+// is gzipped.
+func readStateBytes(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if isGzip(raw) {
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing state file %s: %s", path, err)
+		}
+
+		raw, err = ioutil.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing state file %s: %s", path, err)
+		}
+	}
+
+	return raw, nil
+}
+
+// writeFileAtomic writes a file by calling fn with a temporary file in
+// the same directory, syncing it and then renaming it over path. A crash
+// part way through leaves either the old or the new contents at path,
+// never a partial file. If path is a symlink, its target is replaced.
+//
+// Writes by fn are buffered, so it can write the file in small pieces.
+func writeFileAtomic(path string, fn func(io.Writer) error) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	buf := bufio.NewWriter(f)
+	err = fn(buf)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// isGzip returns true if raw starts with the gzip magic bytes.
+func isGzip(raw []byte) bool {
+	return len(raw) >= 2 && raw[0] == 0x1f && raw[1] == 0x8b
+}
+
+// unknownStateKeys returns the sorted top-level keys of the state JSON in
+// raw that don't correspond to a field of terraform.State.
+func unknownStateKeys(raw []byte) []string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(terraform.State{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	var result []string
+	for k := range fields {
+		if !known[k] {
+			result = append(result, k)
+		}
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+// lineageRegexp matches the UUIDs that Terraform uses as state lineages.
+var lineageRegexp = regexp.MustCompile(
+	`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// LineageFormatError is returned by LocalState when the state file has a
+// lineage that isn't a UUID.
+type LineageFormatError struct {
+	// Path is the path of the state file.
+	Path string
+
+	// Lineage is the invalid lineage found in the state file.
+	Lineage string
+}
+
+func (e *LineageFormatError) Error() string {
+	return fmt.Sprintf(
+		"State file %s has an invalid lineage %q: the lineage must be a UUID.\n\n"+
+			"The state was likely modified outside of Terraform. Correct the\n"+
+			"lineage or remove it to have a new one generated.",
+		e.Path, e.Lineage)
+}
+
+// checkStoredState returns a StateConflictError if the state stored at
+// path can't safely be replaced by state. A missing file is fine.
+func checkStoredState(path string, state *terraform.State) error {
+	raw, err := readStateBytes(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	stored, err := terraform.ReadState(bytes.NewReader(raw))
+	if err != nil {
+		return parseError(path, raw, err)
+	}
+
+	if !stored.SameLineage(state) || stored.Serial > state.Serial {
+		reason := "serial is newer"
+		if !stored.SameLineage(state) {
+			reason = "lineage differs"
+		}
+
+		return &StateConflictError{
+			RemoteConflictError: RemoteConflictError{
+				Path:   path,
+				Reason: reason,
+			},
+			StoredLineage: stored.Lineage,
+			StoredSerial:  stored.Serial,
+			Lineage:       state.Lineage,
+			Serial:        state.Serial,
+		}
+	}
+
+	return nil
+}
+
+// StateConflictError is returned by LocalState when CheckStoredState is
+// set and the state being written would replace a state with a different
+// lineage or a newer serial.
+type StateConflictError struct {
+	// RemoteConflictError holds the path of the stored state.
+	RemoteConflictError
+
+	// StoredLineage and StoredSerial are from the stored state.
+	StoredLineage string
+	StoredSerial  int64
+
+	// Lineage and Serial are from the state being written.
+	Lineage string
+	Serial  int64
+}
+
+func (e *StateConflictError) Error() string {
+	if e.StoredLineage != e.Lineage {
+		return fmt.Sprintf(
+			"State file %s has lineage %q, but the state being written has\n"+
+				"lineage %q. These are different states, so writing would lose\n"+
+				"the stored one. Use -force to overwrite it anyway.",
+			e.Path, e.StoredLineage, e.Lineage)
+	}
+
+	return fmt.Sprintf(
+		"State file %s has serial %d, which is newer than the serial %d of\n"+
+			"the state being written. The stored state was changed since it\n"+
+			"was read, so writing would lose those changes. Refresh and try\n"+
+			"again, or use -force to overwrite it anyway.",
+		e.Path, e.StoredSerial, e.Serial)
+}
+
+// StateParseError is returned by LocalState when the state file is not
+// valid JSON, or its JSON doesn't match the structure of a state.
+type StateParseError struct {
+	// Path is the path of the state file that failed to parse.
+	Path string
+
+	// Offset is the byte offset in the file where parsing failed.
+	Offset int64
+
+	// Hint is a human-readable suggestion for how to fix the state file.
+	Hint string
+
+	// Err is the underlying error returned by Terraform when reading
+	// the state.
+	Err error
+}
+
+func (e *StateParseError) Error() string {
+	return fmt.Sprintf(
+		"Error parsing state file %s at byte offset %d: %s\n\n%s",
+		e.Path, e.Offset, e.Err, e.Hint)
+}
+
+// parseError turns an error from terraform.ReadState into a
+// StateParseError if the cause was a JSON syntax or type error. Other
+// errors, such as unsupported state versions, are returned unchanged.
+func parseError(path string, raw []byte, err error) error {
+	// ReadState only preserves the text of the JSON error, so decode
+	// again to recover the offset and the kind of error.
+	jsonErr := json.Unmarshal(raw, new(terraform.State))
+
+	switch e := jsonErr.(type) {
+	case *json.SyntaxError:
+		hint := fmt.Sprintf(
+			"The state file contains invalid JSON. If it was edited by hand,\n"+
+				"fix the syntax near the reported offset, or restore the state\n"+
+				"from a backup such as %s.backup.", path)
+		if e.Offset >= int64(len(bytes.TrimSpace(raw))) {
+			hint = fmt.Sprintf(
+				"The state file appears to be truncated, possibly due to an\n"+
+					"interrupted write. Restore the state from a backup such as\n"+
+					"%s.backup.", path)
+		}
+
+		return &StateParseError{Path: path, Offset: e.Offset, Hint: hint, Err: err}
+	case *json.UnmarshalTypeError:
+		hint := fmt.Sprintf(
+			"The state file is valid JSON but a value has the wrong type: a\n"+
+				"%s was found where a %s was expected. The state was likely\n"+
+				"modified outside of Terraform; correct the value or restore\n"+
+				"the state from a backup such as %s.backup.",
+			e.Value, e.Type, path)
+
+		return &StateParseError{Path: path, Offset: e.Offset, Hint: hint, Err: err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,18 @@
+// +build solaris
+
+package state
+
+import (
+	"log"
+	"os"
+)
+
+// Solaris has no flock, so local state locking is a no-op there.
+func lockFile(f *os.File) error {
+	log.Printf("[WARN] state: locking local state isn't supported on solaris")
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
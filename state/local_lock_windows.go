@@ -0,0 +1,56 @@
+// +build windows
+
+package state
+
+import (
+	"math"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	modkernel32      = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = modkernel32.NewProc("LockFileEx")
+	procUnlockFileEx = modkernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	_LOCKFILE_FAIL_IMMEDIATELY = 0x1
+	_LOCKFILE_EXCLUSIVE_LOCK   = 0x2
+)
+
+// lockFile takes an exclusive lock on f without blocking. Windows locks
+// are mandatory, so a single byte far past the end of the file is locked
+// to leave the lock info readable by whoever finds the state locked.
+func lockFile(f *os.File) error {
+	ol := lockOverlapped()
+	r, _, err := procLockFileEx.Call(
+		f.Fd(),
+		uintptr(_LOCKFILE_EXCLUSIVE_LOCK|_LOCKFILE_FAIL_IMMEDIATELY),
+		0, 1, 0,
+		uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	ol := lockOverlapped()
+	r, _, err := procUnlockFileEx.Call(
+		f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(ol)))
+	if r == 0 {
+		return err
+	}
+
+	return nil
+}
+
+func lockOverlapped() *syscall.Overlapped {
+	return &syscall.Overlapped{
+		Offset:     math.MaxUint32,
+		OffsetHigh: math.MaxInt32,
+	}
+}
@@ -1,8 +1,16 @@
 package state
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/terraform"
@@ -29,6 +37,73 @@ func TestLocalState_pathOut(t *testing.T) {
 	TestState(t, ls)
 }
 
+func TestLocalState_compressed(t *testing.T) {
+	ls := testLocalState(t)
+	ls.Compressed = true
+	defer os.Remove(ls.Path)
+	TestState(t, ls)
+
+	raw, err := ioutil.ReadFile(ls.Path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !isGzip(raw) {
+		t.Fatalf("state was not compressed: %q", raw)
+	}
+}
+
+func TestLocalState_readGzipWithoutExtension(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	gz := gzip.NewWriter(f)
+	err = terraform.WriteState(TestStateInitial(), gz)
+	gz.Close()
+	f.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ls := &LocalState{Path: f.Name()}
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if actual := ls.State(); !actual.Equal(TestStateInitial()) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestLocalState_rawBytes(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	// Cached from the RefreshState in testLocalState
+	raw, err := ls.RawBytes()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Read fresh after a write
+	if err := ls.WriteState(TestStateInitial()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	raw, err = ls.RawBytes()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
 func TestLocalState_nonExist(t *testing.T) {
 	ls := &LocalState{Path: "ishouldntexist"}
 	if err := ls.RefreshState(); err != nil {
@@ -40,11 +115,585 @@ func TestLocalState_nonExist(t *testing.T) {
 	}
 }
 
+func TestLocalState_nonExistRequired(t *testing.T) {
+	ls := &LocalState{Path: "ishouldntexist", RequireExistingState: true}
+	err := ls.RefreshState()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	expected := "state file not found at ishouldntexist"
+	if err.Error() != expected {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestLocalState_parseError(t *testing.T) {
+	cases := map[string]struct {
+		Input  string
+		Offset int64
+		Hint   string
+	}{
+		"truncated": {
+			`{"version": 3, "serial": 1, "modules": [`,
+			40,
+			"appears to be truncated",
+		},
+
+		"invalid syntax": {
+			`{"version": 3, "serial": 1,, "modules": []}`,
+			28,
+			"contains invalid JSON",
+		},
+
+		"wrong type": {
+			`{"version": 3, "serial": "one", "modules": []}`,
+			30,
+			"value has the wrong type",
+		},
+	}
+
+	for name, tc := range cases {
+		f, err := ioutil.TempFile("", "tf")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		_, err = f.WriteString(tc.Input)
+		f.Close()
+		defer os.Remove(f.Name())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		ls := &LocalState{Path: f.Name()}
+		err = ls.RefreshState()
+		perr, ok := err.(*StateParseError)
+		if !ok {
+			t.Fatalf("%s: expected *StateParseError, got: %#v", name, err)
+		}
+
+		if perr.Path != f.Name() {
+			t.Fatalf("%s: bad path: %s", name, perr.Path)
+		}
+		if perr.Offset != tc.Offset {
+			t.Fatalf("%s: bad offset: %d", name, perr.Offset)
+		}
+		if !strings.Contains(perr.Hint, tc.Hint) {
+			t.Fatalf("%s: bad hint: %s", name, perr.Hint)
+		}
+	}
+}
+
+func TestLocalState_invalidLineage(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, err = f.WriteString(`{"version": 3, "serial": 1, "lineage": "not-a-uuid"}`)
+	f.Close()
+	defer os.Remove(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ls := &LocalState{Path: f.Name()}
+	err = ls.RefreshState()
+	lerr, ok := err.(*LineageFormatError)
+	if !ok {
+		t.Fatalf("expected *LineageFormatError, got: %#v", err)
+	}
+	if lerr.Lineage != "not-a-uuid" {
+		t.Fatalf("bad lineage: %s", lerr.Lineage)
+	}
+
+	ls = &LocalState{Path: f.Name(), RegenerateLineage: true}
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	lineage := ls.State().Lineage
+	if lineage == "not-a-uuid" || !lineageRegexp.MatchString(lineage) {
+		t.Fatalf("bad lineage: %s", lineage)
+	}
+}
+
+func TestLocalState_skipUnchangedWrites(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	ls.SkipUnchangedWrites = true
+
+	state := ls.State()
+	if err := ls.WriteState(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Clobber the file so we can tell whether it is written again
+	if err := ioutil.WriteFile(ls.Path, []byte("clobbered"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Writing the same state again should be skipped
+	if err := ls.WriteState(state.DeepCopy()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if raw, err := ioutil.ReadFile(ls.Path); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if string(raw) != "clobbered" {
+		t.Fatal("unchanged state should not be written")
+	}
+
+	// Any change must still be written
+	state = ls.State()
+	state.RootModule().Outputs["changed"] = &terraform.OutputState{
+		Type:  "string",
+		Value: "yes",
+	}
+	if err := ls.WriteState(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := &LocalState{Path: ls.Path}
+	if err := actual.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := actual.State().RootModule().Outputs["changed"]; !ok {
+		t.Fatal("changed state should be written")
+	}
+}
+
+func TestLocalState_checkStoredState(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	ls.CheckStoredState = true
+
+	state := ls.State()
+
+	// Something else writes a newer state to the same path
+	other := &LocalState{Path: ls.Path}
+	if err := other.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	newer := other.State()
+	newer.Serial++
+	if err := other.WriteState(newer); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := ls.WriteState(state)
+	if _, ok := err.(*StateConflictError); !ok {
+		t.Fatalf("expected StateConflictError for an older serial, got: %v", err)
+	}
+
+	// A state of a different lineage is refused whatever its serial
+	state = newer.DeepCopy()
+	state.Serial++
+	state.Lineage = "9d5a5f8e-6c6c-4c8a-9b4e-3f4f2b2a8b7e"
+	err = ls.WriteState(state)
+	if _, ok := err.(*StateConflictError); !ok {
+		t.Fatalf("expected StateConflictError for a different lineage, got: %v", err)
+	}
+
+	// Without the check the write goes through
+	ls.CheckStoredState = false
+	if err := ls.WriteState(state); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestWriteFileAtomic_error(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "terraform.tfstate")
+	if err := ioutil.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A write that fails part way must leave the old file in place
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("crash")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if raw, err := ioutil.ReadFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if string(raw) != "old" {
+		t.Fatalf("file should be unchanged, got: %q", raw)
+	}
+
+	// No temporary files are left behind
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if len(files) != 1 {
+		t.Fatalf("expected only the state file, got %d files", len(files))
+	}
+
+	// A successful write replaces the file and keeps its mode
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write([]byte("new"))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if raw, err := ioutil.ReadFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if string(raw) != "new" {
+		t.Fatalf("bad: %q", raw)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+}
+
+func TestLocalState_marshalJSON(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	raw, err := json.Marshal(ls)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var actual map[string]interface{}
+	if err := json.Unmarshal(raw, &actual); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"path":    ls.Path,
+		"serial":  float64(ls.State().Serial),
+		"lineage": ls.State().Lineage,
+		"locked":  false,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %s", raw)
+	}
+}
+
+func TestLocalState_unknownKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	_, err = f.WriteString(`{"version": 3, "serial": 1, "extra_field": true}`)
+	f.Close()
+	defer os.Remove(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ls := &LocalState{Path: f.Name()}
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ls.Warnings) != 1 || !strings.Contains(ls.Warnings[0], `"extra_field"`) {
+		t.Fatalf("bad: %#v", ls.Warnings)
+	}
+
+	// A state written by us has no unknown keys
+	ls = testLocalState(t)
+	defer os.Remove(ls.Path)
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(ls.Warnings) != 0 {
+		t.Fatalf("bad: %#v", ls.Warnings)
+	}
+}
+
+func TestLocalState_compactState(t *testing.T) {
+	state := terraform.NewState()
+	root := state.RootModule()
+	root.Resources["test_instance.foo"] = &terraform.ResourceState{
+		Type:    "test_instance",
+		Primary: &terraform.InstanceState{ID: "foo"},
+	}
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("test_instance.gone%d", i)
+		root.Resources["test_instance.foo"].Dependencies = append(
+			root.Resources["test_instance.foo"].Dependencies, name)
+		root.Resources[name] = &terraform.ResourceState{Type: "test_instance"}
+	}
+
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = terraform.WriteState(state, f)
+	f.Close()
+	defer os.Remove(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	before, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ls := &LocalState{Path: f.Name()}
+	if err := ls.CompactState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	after, err := os.Stat(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if after.Size() > before.Size()*8/10 {
+		t.Fatalf("state not compacted enough: %d -> %d", before.Size(), after.Size())
+	}
+
+	actual := &LocalState{Path: f.Name()}
+	if err := actual.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual.State().Serial != state.Serial+1 {
+		t.Fatalf("bad serial: %d", actual.State().Serial)
+	}
+	if len(actual.State().RootModule().Resources) != 1 {
+		t.Fatalf("bad: %s", actual.State())
+	}
+}
+
+func TestLocalState_partialLoad(t *testing.T) {
+	state := terraform.NewState()
+	for i := 0; i < 100; i++ {
+		mod := state.AddModule([]string{"root", fmt.Sprintf("m%d", i)})
+		mod.Resources["test_instance.foo"] = &terraform.ResourceState{
+			Type:    "test_instance",
+			Primary: &terraform.InstanceState{ID: fmt.Sprintf("m%d", i)},
+		}
+	}
+	state.AddModule([]string{"root", "m5", "child"})
+
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = terraform.WriteState(state, f)
+	f.Close()
+	defer os.Remove(f.Name())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ls := &LocalState{Path: f.Name()}
+	actual, err := ls.PartialLoad([]string{"module.m5", "module.m42"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var paths []string
+	for _, m := range actual.Modules {
+		paths = append(paths, strings.Join(m.Path, "."))
+	}
+	expected := []string{"root", "root.m42", "root.m5", "root.m5.child"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("bad modules: %#v", paths)
+	}
+
+	// The filter excludes the root module, but it's still there, empty.
+	if root := actual.RootModule(); len(root.Resources) != 0 {
+		t.Fatalf("bad root: %#v", root)
+	}
+	m5 := actual.ModuleByPath([]string{"root", "m5", "child"})
+	if m5.Resources == nil || m5.Outputs == nil {
+		t.Fatalf("module not initialized: %#v", m5)
+	}
+
+	if actual.Lineage != state.Lineage || actual.Serial != state.Serial {
+		t.Fatalf("bad: %#v", actual)
+	}
+	if ls.State() != nil {
+		t.Fatal("partial state should not be kept")
+	}
+}
+
+func TestLocalState_lock(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	info := NewLockInfo()
+	info.Operation = "test"
+	id, err := ls.Lock(info)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if id != info.ID {
+		t.Fatalf("bad id: %s", id)
+	}
+
+	// Another LocalState for the same path can't take the lock, and is
+	// told who has it.
+	other := &LocalState{Path: ls.Path}
+	_, err = other.Lock(NewLockInfo())
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if !strings.Contains(err.Error(), id) {
+		t.Fatalf("error should include the lock info: %s", err)
+	}
+
+	// Locking doesn't get in the way of using the state
+	if err := ls.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := ls.WriteState(ls.State()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ls.Unlock("wrong"); err == nil {
+		t.Fatal("should error")
+	}
+	if err := ls.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if raw, err := ioutil.ReadFile(ls.lockInfoPath()); err != nil || len(raw) != 0 {
+		t.Fatalf("lock info should be cleared: %q, %v", raw, err)
+	}
+	if info, err := other.LockInfo(); err != nil || info != nil {
+		t.Fatalf("should not be locked: %v, %v", info, err)
+	}
+
+	id, err = other.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := other.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestLocalState_lockContention(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	defer os.Remove(ls.lockInfoPath())
+
+	id, err := ls.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Another locker has opened the lock file and is about to try for
+	// the lock as it is released.
+	f, err := os.OpenFile(ls.lockInfoPath(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+
+	if err := ls.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A third locker comes along and takes the lock.
+	other := &LocalState{Path: ls.Path}
+	id, err = other.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer other.Unlock(id)
+
+	// The waiting locker must see the new holder's lock, which it only
+	// can if the lock file wasn't replaced underneath it.
+	if err := lockFile(f); err == nil {
+		unlockFile(f)
+		t.Fatal("two lockers hold the lock")
+	}
+}
+
+func TestLocalState_forceUnlock(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+
+	other := &LocalState{Path: ls.Path}
+	if info, err := other.LockInfo(); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if info != nil {
+		t.Fatalf("should not be locked: %s", info)
+	}
+
+	id, err := ls.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Others can see the lock, but not remove it while it is held
+	info, err := other.LockInfo()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info == nil || info.ID != id {
+		t.Fatalf("bad lock info: %v", info)
+	}
+	if err := other.ForceUnlock(id); err == nil {
+		t.Fatal("should error")
+	}
+
+	// A lock info file left behind without a lock doesn't lock the state
+	if err := ls.lockFile.Close(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	ls.lockFile = nil
+	if info, err := other.LockInfo(); err != nil {
+		t.Fatalf("err: %s", err)
+	} else if info != nil {
+		t.Fatalf("stale lock info should be ignored: %s", info)
+	}
+	os.Remove(ls.lockInfoPath())
+}
+
 func TestLocalState_impl(t *testing.T) {
 	var _ StateReader = new(LocalState)
 	var _ StateWriter = new(LocalState)
 	var _ StatePersister = new(LocalState)
 	var _ StateRefresher = new(LocalState)
+	var _ ForceUnlocker = new(LocalState)
+}
+
+func BenchmarkLocalState_WriteState(b *testing.B) {
+	dir, err := ioutil.TempDir("", "tf")
+	if err != nil {
+		b.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := terraform.NewState()
+	for i := 0; i < 10; i++ {
+		mod := state.AddModule([]string{"root", fmt.Sprintf("child%d", i)})
+		for j := 0; j < 500; j++ {
+			mod.Resources[fmt.Sprintf("aws_instance.foo.%d", j)] = &terraform.ResourceState{
+				Type: "aws_instance",
+				Primary: &terraform.InstanceState{
+					ID: fmt.Sprintf("i-%08d", j),
+					Attributes: map[string]string{
+						"id":            fmt.Sprintf("i-%08d", j),
+						"ami":           "ami-abcd1234",
+						"instance_type": "t2.micro",
+					},
+				},
+			}
+		}
+	}
+
+	ls := &LocalState{Path: filepath.Join(dir, "terraform.tfstate")}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ls.WriteState(state); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
 }
 
 func testLocalState(t *testing.T) *LocalState {
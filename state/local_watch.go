@@ -0,0 +1,99 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+	"gopkg.in/fsnotify.v1"
+)
+
+// DefaultWatchPollInterval is the interval at which Watch checks the
+// state file for changes when LocalState.PollInterval isn't set.
+const DefaultWatchPollInterval = 1 * time.Second
+
+// Watch calls onChange with the newly read state whenever the state file
+// changes on disk, until ctx is cancelled. The file is watched with
+// filesystem notifications where they are available and is also polled
+// every PollInterval, which catches changes the notifications miss.
+//
+// Watch doesn't modify the LocalState itself; call RefreshState to load
+// a changed state into it. Watch returns ctx.Err() once ctx is done.
+func (s *LocalState) Watch(
+	ctx context.Context, onChange func(*terraform.State)) error {
+	path := s.readPath()
+
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultWatchPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Watch the directory rather than the file, since the file may be
+	// replaced rather than written in place. If notifications aren't
+	// available we just rely on polling.
+	var events chan fsnotify.Event
+	var errors chan error
+	if w, err := fsnotify.NewWatcher(); err != nil {
+		log.Printf("[WARN] state: watching %s by polling only: %s", path, err)
+	} else {
+		defer w.Close()
+		if err := w.Add(filepath.Dir(path)); err != nil {
+			log.Printf("[WARN] state: watching %s by polling only: %s", path, err)
+		} else {
+			events = w.Events
+			errors = w.Errors
+		}
+	}
+
+	// Compare the contents rather than the size and modification time,
+	// which miss a rewrite of the same size within the resolution of the
+	// modification time.
+	var last [sha256.Size]byte
+	if raw, err := readStateBytes(path); err == nil {
+		last = sha256.Sum256(raw)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-events:
+			if filepath.Clean(e.Name) != filepath.Clean(path) {
+				continue
+			}
+		case err := <-errors:
+			// The watcher blocks until its errors are read, so read them
+			// even though polling will catch anything missed.
+			log.Printf("[WARN] state: error watching %s: %s", path, err)
+			continue
+		case <-ticker.C:
+		}
+
+		raw, err := readStateBytes(path)
+		if err != nil {
+			// The file may have been removed or be mid-write; we'll
+			// notice again on the next check.
+			log.Printf("[DEBUG] state: error reading watched %s: %s", path, err)
+			continue
+		}
+
+		current := sha256.Sum256(raw)
+		if current == last {
+			continue
+		}
+
+		state, err := terraform.ReadState(bytes.NewReader(raw))
+		if err != nil {
+			log.Printf("[DEBUG] state: error parsing watched %s: %s", path, err)
+			continue
+		}
+
+		last = current
+		onChange(state)
+	}
+}
@@ -0,0 +1,124 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestLocalStateWatch(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	ls.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan *terraform.State, 10)
+	doneCh := make(chan error)
+	go func() {
+		doneCh <- ls.Watch(ctx, func(s *terraform.State) {
+			changed <- s
+		})
+	}()
+
+	// Write a new state from another LocalState, as another process would
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		other := &LocalState{Path: ls.Path}
+		if err := other.RefreshState(); err != nil {
+			t.Errorf("err: %s", err)
+			return
+		}
+
+		s := other.State()
+		s.RootModule().Outputs["watched"] = &terraform.OutputState{
+			Type:  "string",
+			Value: "yes",
+		}
+		if err := other.WriteState(s); err != nil {
+			t.Errorf("err: %s", err)
+		}
+	}()
+
+	select {
+	case s := <-changed:
+		if _, ok := s.RootModule().Outputs["watched"]; !ok {
+			t.Fatalf("bad: %s", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+
+	cancel()
+	select {
+	case err := <-doneCh:
+		if err != context.Canceled {
+			t.Fatalf("bad: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Watch to return")
+	}
+}
+
+func TestLocalStateWatch_sameSize(t *testing.T) {
+	ls := testLocalState(t)
+	defer os.Remove(ls.Path)
+	ls.PollInterval = 10 * time.Millisecond
+
+	s := terraform.NewState()
+	s.RootModule().Outputs["watched"] = &terraform.OutputState{
+		Type:  "string",
+		Value: "aaa",
+	}
+	var buf bytes.Buffer
+	if err := terraform.WriteState(s, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	before := buf.Bytes()
+	after := bytes.Replace(before, []byte(`"aaa"`), []byte(`"bbb"`), 1)
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := ioutil.WriteFile(ls.Path, before, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Chtimes(ls.Path, mtime, mtime); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan *terraform.State, 10)
+	go ls.Watch(ctx, func(s *terraform.State) {
+		changed <- s
+	})
+
+	// Rewrite the file with contents of the same size and put the
+	// modification time back, so only the contents tell them apart
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if err := ioutil.WriteFile(ls.Path, after, 0644); err != nil {
+			t.Errorf("err: %s", err)
+			return
+		}
+		if err := os.Chtimes(ls.Path, mtime, mtime); err != nil {
+			t.Errorf("err: %s", err)
+		}
+	}()
+
+	select {
+	case s := <-changed:
+		o := s.RootModule().Outputs["watched"]
+		if o == nil || o.Value != "bbb" {
+			t.Fatalf("bad: %s", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+}
@@ -0,0 +1,148 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/satori/go.uuid"
+)
+
+// LockInfo is the metadata stored with a state lock, so that whoever
+// finds the state locked can tell who holds the lock and why.
+type LockInfo struct {
+	// ID is the unique ID of the lock. If empty when passed to
+	// Locker.Lock, one is generated.
+	ID string
+
+	// Operation is the Terraform operation holding the lock, such as
+	// "apply".
+	Operation string
+
+	// Info is any extra information about the lock.
+	Info string
+
+	// Who is the user and host holding the lock, as "user@host".
+	Who string
+
+	// Version is the version of Terraform holding the lock.
+	Version string
+
+	// Created is when the lock was taken.
+	Created time.Time
+
+	// Path is the path of the locked state, set by the Locker.
+	Path string
+}
+
+// NewLockInfo returns a LockInfo with a new ID and the current user,
+// host, Terraform version and time filled in.
+func NewLockInfo() *LockInfo {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	who := host
+	if u, err := user.Current(); err == nil {
+		who = fmt.Sprintf("%s@%s", u.Username, host)
+	} else if name := os.Getenv("USER"); name != "" {
+		who = fmt.Sprintf("%s@%s", name, host)
+	}
+
+	return &LockInfo{
+		ID:      uuid.NewV4().String(),
+		Who:     who,
+		Version: terraform.VersionString(),
+		Created: time.Now().UTC(),
+	}
+}
+
+func (i *LockInfo) String() string {
+	return fmt.Sprintf(
+		"ID: %s, Operation: %s, Who: %s, Version: %s, Created: %s, Info: %s",
+		i.ID, i.Operation, i.Who, i.Version, i.Created, i.Info)
+}
+
+// LockError is returned by a Locker when the state is already locked by
+// someone else. Info is the info of the lock that is held, if it could be
+// read, so that callers can report who holds it.
+type LockError struct {
+	// Path is the path of the locked state.
+	Path string
+
+	// Info is the info of the held lock, or nil if it isn't known.
+	Info *LockInfo
+
+	// Err is the error from trying to take the lock, if any.
+	Err error
+}
+
+func (e *LockError) Error() string {
+	msg := "state is locked"
+	if e.Path != "" {
+		msg = fmt.Sprintf("state %s is locked", e.Path)
+	}
+
+	if e.Info == nil {
+		msg += " by another process"
+		if e.Err != nil {
+			msg = fmt.Sprintf("%s: %s", msg, e.Err)
+		}
+
+		return msg
+	}
+
+	// Summarize who holds the lock, then give the full details
+	who := e.Info.Who
+	if who == "" {
+		who = "another process"
+	}
+	msg = fmt.Sprintf("%s by %s", msg, who)
+	if e.Info.Operation != "" {
+		msg = fmt.Sprintf("%s running %s", msg, e.Info.Operation)
+	}
+	if !e.Info.Created.IsZero() {
+		msg = fmt.Sprintf("%s since %s", msg, e.Info.Created.Format(time.RFC1123))
+	}
+
+	return fmt.Sprintf("%s:\n\n%s", msg, e.Info)
+}
+
+// The wrapping states pass locking through to the state they wrap, with
+// these helpers. A wrapped state that can't be locked behaves as if it is
+// never locked.
+
+func lockState(s interface{}, info *LockInfo) (string, error) {
+	if l, ok := s.(Locker); ok {
+		return l.Lock(info)
+	}
+
+	return "", nil
+}
+
+func unlockState(s interface{}, id string) error {
+	if l, ok := s.(Locker); ok {
+		return l.Unlock(id)
+	}
+
+	return nil
+}
+
+func stateLockInfo(s interface{}) (*LockInfo, error) {
+	if l, ok := s.(ForceUnlocker); ok {
+		return l.LockInfo()
+	}
+
+	return nil, nil
+}
+
+func forceUnlockState(s interface{}, id string) error {
+	if l, ok := s.(ForceUnlocker); ok {
+		return l.ForceUnlock(id)
+	}
+
+	return fmt.Errorf("the state doesn't support force-unlocking")
+}
@@ -0,0 +1,45 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockError(t *testing.T) {
+	created := time.Date(2017, 1, 2, 10, 32, 0, 0, time.UTC)
+	cases := []struct {
+		Err      *LockError
+		Expected string
+	}{
+		{
+			&LockError{},
+			"state is locked by another process",
+		},
+		{
+			&LockError{Path: "terraform.tfstate", Err: errors.New("busy")},
+			"state terraform.tfstate is locked by another process: busy",
+		},
+		{
+			&LockError{
+				Path: "terraform.tfstate",
+				Info: &LockInfo{
+					ID:        "abc",
+					Operation: "apply",
+					Who:       "alice@ci-runner-3",
+					Created:   created,
+				},
+			},
+			"state terraform.tfstate is locked by alice@ci-runner-3 " +
+				"running apply since Mon, 02 Jan 2017 10:32:00 UTC:\n\nID: abc",
+		},
+	}
+
+	for i, tc := range cases {
+		actual := tc.Err.Error()
+		if !strings.HasPrefix(actual, tc.Expected) {
+			t.Fatalf("%d: bad:\n\n%s\n\nexpected prefix:\n\n%s", i, actual, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package state
+
+import (
+	"errors"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// ErrReadOnly is returned when writing to a ReadOnlyState.
+var ErrReadOnly = errors.New("state is read-only")
+
+// ReadOnlyState wraps a State so that it can be read and refreshed but
+// never written: WriteState, PersistState and ForceUnlock return
+// ErrReadOnly without calling the real state.
+//
+// Since nothing is written, a read-only state doesn't need the lock.
+// Lock and Unlock succeed without locking the real state, so reading
+// works even while another process holds the lock.
+type ReadOnlyState struct {
+	Real State
+}
+
+func (s *ReadOnlyState) State() *terraform.State {
+	return s.Real.State()
+}
+
+func (s *ReadOnlyState) RefreshState() error {
+	return s.Real.RefreshState()
+}
+
+func (s *ReadOnlyState) WriteState(state *terraform.State) error {
+	return ErrReadOnly
+}
+
+func (s *ReadOnlyState) PersistState() error {
+	return ErrReadOnly
+}
+
+// Locker impl.
+func (s *ReadOnlyState) Lock(info *LockInfo) (string, error) {
+	return "", nil
+}
+
+// Locker impl.
+func (s *ReadOnlyState) Unlock(id string) error {
+	return nil
+}
+
+// LockInfo returns the info of the lock on the real state, so that
+// readers can still see who holds it.
+//
+// ForceUnlocker impl.
+func (s *ReadOnlyState) LockInfo() (*LockInfo, error) {
+	return stateLockInfo(s.Real)
+}
+
+// ForceUnlocker impl.
+func (s *ReadOnlyState) ForceUnlock(id string) error {
+	return ErrReadOnly
+}
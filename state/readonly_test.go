@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestReadOnlyState_impl(t *testing.T) {
+	var _ StateReader = new(ReadOnlyState)
+	var _ StateWriter = new(ReadOnlyState)
+	var _ StatePersister = new(ReadOnlyState)
+	var _ StateRefresher = new(ReadOnlyState)
+	var _ ForceUnlocker = new(ReadOnlyState)
+}
+
+func TestReadOnlyState(t *testing.T) {
+	real := &InmemState{}
+	if err := real.WriteState(TestStateInitial()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	s := &ReadOnlyState{Real: real}
+	if err := s.RefreshState(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !s.State().Equal(real.State()) {
+		t.Fatalf("bad: %s", s.State())
+	}
+
+	original := real.State()
+	state := s.State()
+	state.Modules = nil
+	if err := s.WriteState(state); err != ErrReadOnly {
+		t.Fatalf("bad: %v", err)
+	}
+	if err := s.PersistState(); err != ErrReadOnly {
+		t.Fatalf("bad: %v", err)
+	}
+	if !real.State().Equal(original) {
+		t.Fatal("real state should not have been written")
+	}
+}
+
+func TestReadOnlyState_lock(t *testing.T) {
+	real := &InmemState{}
+	id, err := real.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Reading doesn't need the lock, even when it is held
+	s := &ReadOnlyState{Real: real}
+	roID, err := s.Lock(NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.Unlock(roID); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	info, err := s.LockInfo()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if info == nil || info.ID != id {
+		t.Fatalf("bad: %#v", info)
+	}
+	if err := s.ForceUnlock(id); err != ErrReadOnly {
+		t.Fatalf("bad: %v", err)
+	}
+}
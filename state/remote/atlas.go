@@ -340,7 +340,7 @@ func (c *AtlasClient) handleConflict(msg string, state []byte) error {
 
 		if statesAreEquivalent(currentState, proposedState) {
 			log.Printf("[DEBUG] States are equivalent, incrementing serial and retrying.")
-			proposedState.Serial++
+			proposedState.IncrementSerial()
 			var buf bytes.Buffer
 			if err := terraform.WriteState(proposedState, &buf); err != nil {
 				return conflictHandlingError(err)
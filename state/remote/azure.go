@@ -2,16 +2,25 @@ package remote
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 
 	"github.com/Azure/azure-sdk-for-go/arm/storage"
 	mainStorage "github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/hashicorp/terraform/state"
 	riviera "github.com/jen20/riviera/azure"
+	"github.com/satori/go.uuid"
 )
 
+// azureLockInfoKey is the blob metadata key the lock info is stored
+// under while the state is locked.
+const azureLockInfoKey = "terraformlockinfo"
+
 func azureFactory(conf map[string]string) (Client, error) {
 	storageAccountName, ok := conf["storage_account_name"]
 	if !ok {
@@ -133,6 +142,12 @@ type AzureClient struct {
 	containerName string
 	keyName       string
 	leaseID       string
+
+	// lockInfo is the info of the lock we hold, if any, and configLeaseID
+	// is the lease_id that was configured, which leaseID is reset to when
+	// the lock is released.
+	lockInfo      *state.LockInfo
+	configLeaseID string
 }
 
 func (c *AzureClient) Get() (*Payload, error) {
@@ -143,7 +158,7 @@ func (c *AzureClient) Get() (*Payload, error) {
 				return nil, nil
 			}
 		}
-		return nil, err
+		return nil, c.storageError(err)
 	}
 
 	defer blob.Close()
@@ -174,13 +189,47 @@ func (c *AzureClient) Put(data []byte) error {
 		headers["x-ms-lease-id"] = c.leaseID
 	}
 
-	return c.blobClient.CreateBlockBlobFromReader(
+	// Writing the blob replaces its metadata, so keep the lock info
+	if c.lockInfo != nil {
+		v, err := encodeAzureLockInfo(c.lockInfo)
+		if err != nil {
+			return err
+		}
+		headers["x-ms-meta-"+azureLockInfoKey] = v
+	}
+
+	err := c.blobClient.CreateBlockBlobFromReader(
 		c.containerName,
 		c.keyName,
 		uint64(len(data)),
 		bytes.NewReader(data),
 		headers,
 	)
+	return c.storageError(err)
+}
+
+// storageError returns a failed request for the state blob as an error
+// that state.RetryState can act on. A failed condition or lease means
+// someone else changed or locked the blob, and isn't worth retrying,
+// while a server error is.
+func (c *AzureClient) storageError(err error) error {
+	storErr, ok := err.(mainStorage.AzureStorageServiceError)
+	if !ok {
+		return err
+	}
+
+	switch {
+	case storErr.StatusCode == http.StatusPreconditionFailed ||
+		storErr.StatusCode == http.StatusConflict:
+		return &state.RemoteConflictError{
+			Path:   fmt.Sprintf("%s/%s", c.containerName, c.keyName),
+			Reason: storErr.Code,
+		}
+	case state.RetryableStatus(storErr.StatusCode):
+		return &state.TransientError{Err: err}
+	}
+
+	return err
 }
 
 func (c *AzureClient) Delete() error {
@@ -191,3 +240,166 @@ func (c *AzureClient) Delete() error {
 
 	return c.blobClient.DeleteBlob(c.containerName, c.keyName, headers)
 }
+
+// Lock locks the state by taking an infinite lease on the state blob,
+// creating an empty blob if there is no state yet. The lock info is
+// stored in the blob's metadata. Azure rejects writes to a leased blob
+// that don't give the lease ID, so other runs can't change the state.
+func (c *AzureClient) Lock(info *state.LockInfo) (string, error) {
+	if c.lockInfo != nil {
+		return "", fmt.Errorf("state %s/%s is already locked", c.containerName, c.keyName)
+	}
+
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = fmt.Sprintf("%s/%s", c.containerName, c.keyName)
+
+	// Only an existing blob can be leased
+	exists, err := c.blobClient.BlobExists(c.containerName, c.keyName)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		err := c.blobClient.CreateBlockBlobFromReader(
+			c.containerName, c.keyName, 0, bytes.NewReader(nil), nil)
+		if err != nil {
+			return "", fmt.Errorf("Error creating the state blob to lock: %s", err)
+		}
+	}
+
+	// Azure lease IDs must be GUIDs, so lease with a new one rather than
+	// the lock ID.
+	leaseID, err := c.blobClient.AcquireLease(
+		c.containerName, c.keyName, -1, uuid.NewV4().String())
+	if err != nil {
+		if holder, _ := c.LockInfo(); holder != nil {
+			return "", &state.LockError{Path: info.Path, Info: holder, Err: err}
+		}
+
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+
+	v, err := encodeAzureLockInfo(info)
+	if err == nil {
+		err = c.blobClient.SetBlobMetadata(
+			c.containerName, c.keyName,
+			map[string]string{azureLockInfoKey: v},
+			map[string]string{"x-ms-lease-id": leaseID})
+	}
+	if err != nil {
+		c.blobClient.ReleaseLease(c.containerName, c.keyName, leaseID)
+		return "", fmt.Errorf("Error writing the state lock info: %s", err)
+	}
+
+	c.configLeaseID = c.leaseID
+	c.leaseID = leaseID
+	c.lockInfo = info
+	return info.ID, nil
+}
+
+// Unlock releases the lock taken by Lock if id matches it.
+func (c *AzureClient) Unlock(id string) error {
+	if c.lockInfo == nil {
+		return fmt.Errorf("state %s/%s is not locked", c.containerName, c.keyName)
+	}
+	if c.lockInfo.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s/%s",
+			id, c.containerName, c.keyName)
+	}
+
+	err := c.blobClient.SetBlobMetadata(
+		c.containerName, c.keyName, map[string]string{},
+		map[string]string{"x-ms-lease-id": c.leaseID})
+	if err != nil {
+		return fmt.Errorf("Error removing the state lock info: %s", err)
+	}
+
+	err = c.blobClient.ReleaseLease(c.containerName, c.keyName, c.leaseID)
+	if err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	c.leaseID = c.configLeaseID
+	c.lockInfo = nil
+	return nil
+}
+
+// LockInfo returns the info of the current lock, or nil if the state
+// isn't locked.
+func (c *AzureClient) LockInfo() (*state.LockInfo, error) {
+	props, err := c.blobClient.GetBlobProperties(c.containerName, c.keyName)
+	if err != nil {
+		if storErr, ok := err.(mainStorage.AzureStorageServiceError); ok && storErr.Code == "BlobNotFound" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if props.LeaseStatus != "locked" {
+		return nil, nil
+	}
+
+	meta, err := c.blobClient.GetBlobMetadata(c.containerName, c.keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(meta[azureLockInfoKey])
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("state is leased, but has no lock info")
+	}
+
+	info := new(state.LockInfo)
+	if err := json.Unmarshal(raw, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ForceUnlock breaks the lease on the state blob if the lock ID matches,
+// and removes the lock info.
+func (c *AzureClient) ForceUnlock(id string) error {
+	if c.lockInfo != nil {
+		return c.Unlock(id)
+	}
+
+	info, err := c.LockInfo()
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf("state %s/%s is not locked", c.containerName, c.keyName)
+	}
+	if info.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s/%s",
+			id, c.containerName, c.keyName)
+	}
+
+	_, err = c.blobClient.BreakLeaseWithBreakPeriod(c.containerName, c.keyName, 0)
+	if err != nil {
+		return fmt.Errorf("Error breaking the state lock: %s", err)
+	}
+
+	err = c.blobClient.SetBlobMetadata(
+		c.containerName, c.keyName, map[string]string{}, nil)
+	if err != nil {
+		return fmt.Errorf("Error removing the state lock info: %s", err)
+	}
+
+	return nil
+}
+
+func encodeAzureLockInfo(info *state.LockInfo) (string, error) {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
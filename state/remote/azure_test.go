@@ -15,6 +15,7 @@ import (
 
 func TestAzureClient_impl(t *testing.T) {
 	var _ Client = new(AzureClient)
+	var _ ClientForceUnlocker = new(AzureClient)
 }
 
 // This test creates a bucket in Azure and populates it.
@@ -1,11 +1,18 @@
 package remote
 
 import (
+	"bytes"
+	"compress/gzip"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"strconv"
 	"strings"
 
 	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/terraform/state"
+	"github.com/satori/go.uuid"
 )
 
 func consulFactory(conf map[string]string) (Client, error) {
@@ -42,6 +49,17 @@ func consulFactory(conf map[string]string) (Client, error) {
 		}
 	}
 
+	gz := false
+	if raw, ok := conf["gzip"]; ok && raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"'gzip' field couldn't be parsed as bool: %s", err)
+		}
+
+		gz = v
+	}
+
 	client, err := consulapi.NewClient(config)
 	if err != nil {
 		return nil, err
@@ -50,6 +68,7 @@ func consulFactory(conf map[string]string) (Client, error) {
 	return &ConsulClient{
 		Client: client,
 		Path:   path,
+		GZip:   gz,
 	}, nil
 }
 
@@ -57,6 +76,13 @@ func consulFactory(conf map[string]string) (Client, error) {
 type ConsulClient struct {
 	Client *consulapi.Client
 	Path   string
+
+	// GZip compresses the state before it is stored. Compressed state is
+	// always detected and decompressed when read, whatever this is set to.
+	GZip bool
+
+	lock   *consulapi.Lock
+	lockID string
 }
 
 func (c *ConsulClient) Get() (*Payload, error) {
@@ -68,14 +94,39 @@ func (c *ConsulClient) Get() (*Payload, error) {
 		return nil, nil
 	}
 
-	md5 := md5.Sum(pair.Value)
+	data := pair.Value
+	if len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decompress remote state: %s", err)
+		}
+	}
+
+	md5 := md5.Sum(data)
 	return &Payload{
-		Data: pair.Value,
+		Data: data,
 		MD5:  md5[:],
 	}, nil
 }
 
 func (c *ConsulClient) Put(data []byte) error {
+	if c.GZip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		data = buf.Bytes()
+	}
+
 	kv := c.Client.KV()
 	_, err := kv.Put(&consulapi.KVPair{
 		Key:   c.Path,
@@ -89,3 +140,119 @@ func (c *ConsulClient) Delete() error {
 	_, err := kv.Delete(c.Path, nil)
 	return err
 }
+
+// Lock takes a lock on the state using a Consul session, by acquiring
+// the key "<path>/.lock". The lock info is stored as the key's value so
+// that others can see who holds it. Lock doesn't wait if the state is
+// already locked.
+func (c *ConsulClient) Lock(info *state.LockInfo) (string, error) {
+	if c.lock != nil {
+		return "", fmt.Errorf("state %s is already locked", c.Path)
+	}
+
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = c.Path
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	lock, err := c.Client.LockOpts(&consulapi.LockOptions{
+		Key:         c.lockPath(),
+		Value:       raw,
+		SessionName: fmt.Sprintf("terraform state lock for %s", c.Path),
+		LockTryOnce: true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	lockCh, err := lock.Lock(make(chan struct{}))
+	if err != nil {
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+	if lockCh == nil {
+		holder, _ := c.LockInfo()
+		return "", &state.LockError{Path: c.Path, Info: holder}
+	}
+
+	c.lock = lock
+	c.lockID = info.ID
+	return info.ID, nil
+}
+
+// Unlock releases the lock taken by Lock if id matches it.
+func (c *ConsulClient) Unlock(id string) error {
+	if c.lock == nil {
+		return fmt.Errorf("state %s is not locked", c.Path)
+	}
+	if id != c.lockID {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s", id, c.Path)
+	}
+
+	if err := c.lock.Unlock(); err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	c.lock = nil
+	c.lockID = ""
+	return nil
+}
+
+// LockInfo returns the info of the current lock, or nil if the state
+// isn't locked.
+func (c *ConsulClient) LockInfo() (*state.LockInfo, error) {
+	pair, _, err := c.Client.KV().Get(c.lockPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil || pair.Session == "" {
+		return nil, nil
+	}
+
+	info := new(state.LockInfo)
+	if err := json.Unmarshal(pair.Value, info); err != nil {
+		return nil, fmt.Errorf("Error reading the state lock info: %s", err)
+	}
+
+	return info, nil
+}
+
+// ForceUnlock removes the lock with the given ID by destroying the
+// session holding it.
+func (c *ConsulClient) ForceUnlock(id string) error {
+	if c.lock != nil {
+		return c.Unlock(id)
+	}
+
+	pair, _, err := c.Client.KV().Get(c.lockPath(), nil)
+	if err != nil {
+		return err
+	}
+	if pair == nil || pair.Session == "" {
+		return fmt.Errorf("state %s is not locked", c.Path)
+	}
+
+	info := new(state.LockInfo)
+	if err := json.Unmarshal(pair.Value, info); err != nil || info.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s", id, c.Path)
+	}
+
+	if _, err := c.Client.Session().Destroy(pair.Session, nil); err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	return nil
+}
+
+func (c *ConsulClient) lockPath() string {
+	return strings.TrimRight(c.Path, "/") + "/.lock"
+}
@@ -10,6 +10,7 @@ import (
 
 func TestConsulClient_impl(t *testing.T) {
 	var _ Client = new(ConsulClient)
+	var _ ClientForceUnlocker = new(ConsulClient)
 }
 
 func TestConsulClient(t *testing.T) {
@@ -0,0 +1,248 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/pathorcontents"
+	"github.com/hashicorp/terraform/state"
+)
+
+// EncryptionKeyEnvVar is the environment variable that, like the
+// "state_encryption_key_file" configuration, sets the key NewClient uses
+// to encrypt the state.
+const EncryptionKeyEnvVar = "TF_STATE_ENCRYPTION_KEY"
+
+// encryptedStateVersion is the version of the encrypted state format.
+const encryptedStateVersion = 1
+
+// EncryptedClient wraps a Client to encrypt the state with AES-GCM before
+// it is stored, and decrypt it when it is read, so that the remote
+// storage never sees the state in plaintext. Reading state that isn't
+// encrypted is an error, since anyone able to write to the storage could
+// otherwise replace the state without the key.
+//
+// Locking is passed through to the wrapped client.
+type EncryptedClient struct {
+	Client Client
+
+	// Key is the AES key, which must be 16, 24 or 32 bytes long.
+	Key []byte
+
+	// AllowPlaintext allows state that isn't encrypted to be read as-is.
+	// It is meant for switching existing state to encryption, which
+	// happens the next time it is written, and should be unset again
+	// afterwards.
+	AllowPlaintext bool
+}
+
+// encryptedState is the JSON envelope encrypted state is stored in.
+type encryptedState struct {
+	Encrypted struct {
+		Version    int    `json:"version"`
+		Nonce      []byte `json:"nonce"`
+		Ciphertext []byte `json:"ciphertext"`
+	} `json:"terraform_encrypted_state"`
+}
+
+func (c *EncryptedClient) Get() (*Payload, error) {
+	payload, err := c.Client.Get()
+	if err != nil || payload == nil {
+		return payload, err
+	}
+
+	var env encryptedState
+	if err := json.Unmarshal(payload.Data, &env); err != nil || env.Encrypted.Version == 0 {
+		if c.AllowPlaintext {
+			return payload, nil
+		}
+
+		return nil, fmt.Errorf(
+			"The remote state isn't encrypted, but a state encryption key is set.\n" +
+				"If this is existing state being switched to encryption, set\n" +
+				"state_encryption_allow_plaintext to read it this once; it is\n" +
+				"encrypted when it is next written.")
+	}
+	if env.Encrypted.Version != encryptedStateVersion {
+		return nil, fmt.Errorf(
+			"unsupported encrypted state version %d", env.Encrypted.Version)
+	}
+
+	gcm, err := c.gcm()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := gcm.Open(nil, env.Encrypted.Nonce, env.Encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"Error decrypting the state; check that the encryption key is correct: %s", err)
+	}
+
+	md5 := md5.Sum(data)
+	return &Payload{
+		Data: data,
+		MD5:  md5[:],
+	}, nil
+}
+
+func (c *EncryptedClient) Put(data []byte) error {
+	gcm, err := c.gcm()
+	if err != nil {
+		return err
+	}
+
+	var env encryptedState
+	env.Encrypted.Version = encryptedStateVersion
+	env.Encrypted.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, env.Encrypted.Nonce); err != nil {
+		return err
+	}
+	env.Encrypted.Ciphertext = gcm.Seal(nil, env.Encrypted.Nonce, data, nil)
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&env); err != nil {
+		return err
+	}
+
+	// A client that stores the serial can't read it from the ciphertext,
+	// so give it the serial of the plaintext state.
+	if p, ok := c.Client.(ClientSerialPutter); ok {
+		serial, err := stateSerial(data)
+		if err != nil {
+			return err
+		}
+
+		return p.PutSerial(buf.Bytes(), serial)
+	}
+
+	return c.Client.Put(buf.Bytes())
+}
+
+func (c *EncryptedClient) Delete() error {
+	return c.Client.Delete()
+}
+
+func (c *EncryptedClient) Lock(info *state.LockInfo) (string, error) {
+	if l, ok := c.Client.(ClientLocker); ok {
+		return l.Lock(info)
+	}
+
+	return "", nil
+}
+
+func (c *EncryptedClient) Unlock(id string) error {
+	if l, ok := c.Client.(ClientLocker); ok {
+		return l.Unlock(id)
+	}
+
+	return nil
+}
+
+func (c *EncryptedClient) LockInfo() (*state.LockInfo, error) {
+	if l, ok := c.Client.(ClientForceUnlocker); ok {
+		return l.LockInfo()
+	}
+
+	return nil, nil
+}
+
+func (c *EncryptedClient) ForceUnlock(id string) error {
+	if l, ok := c.Client.(ClientForceUnlocker); ok {
+		return l.ForceUnlock(id)
+	}
+
+	return fmt.Errorf("this remote state doesn't support force-unlocking")
+}
+
+func (c *EncryptedClient) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid state encryption key: %s", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// allowPlaintext returns the "state_encryption_allow_plaintext" setting
+// of the configuration.
+func allowPlaintext(conf map[string]string) (bool, error) {
+	raw, ok := conf["state_encryption_allow_plaintext"]
+	if !ok {
+		return false, nil
+	}
+
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf(
+			"state_encryption_allow_plaintext must be a boolean: %s", err)
+	}
+
+	return v, nil
+}
+
+// encryptionKey returns the state encryption key from EncryptionKeyEnvVar,
+// as base64 or a path to a file holding base64, or from the file named by
+// the "state_encryption_key_file" configuration. If neither is set, nil is
+// returned.
+//
+// The remote configuration is saved in plaintext with the local copy of
+// the state, so the key itself can't be given in the configuration.
+func encryptionKey(conf map[string]string) ([]byte, error) {
+	if _, ok := conf["state_encryption_key"]; ok {
+		return nil, fmt.Errorf(
+			"state_encryption_key can't be set in the remote configuration, since\n"+
+				"the configuration is saved in plaintext next to the state. Set the\n"+
+				"%s environment variable, or state_encryption_key_file\n"+
+				"to the path of a file holding the key, instead.", EncryptionKeyEnvVar)
+	}
+	if _, ok := conf["state_encryption_kms_key_id"]; ok {
+		return nil, fmt.Errorf(
+			"KMS keys aren't supported for state encryption. Set the %s\n"+
+				"environment variable or state_encryption_key_file instead.",
+			EncryptionKeyEnvVar)
+	}
+
+	path, fromFile := conf["state_encryption_key_file"]
+	raw := path
+	if !fromFile {
+		raw = os.Getenv(EncryptionKeyEnvVar)
+	}
+	if raw == "" && !fromFile {
+		return nil, nil
+	}
+
+	contents, wasPath, err := pathorcontents.Read(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading the state encryption key: %s", err)
+	}
+	if fromFile && !wasPath {
+		return nil, fmt.Errorf(
+			"state_encryption_key_file must be the path of a file holding the key: %s", path)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(contents))
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding the state encryption key: %s", err)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf(
+			"the state encryption key must be a base64 encoded 128, 192 or "+
+				"256 bit key, got %d bits", len(key)*8)
+	}
+
+	return key, nil
+}
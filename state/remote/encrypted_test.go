@@ -0,0 +1,146 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/state"
+)
+
+func TestEncryptedClient_impl(t *testing.T) {
+	var _ Client = new(EncryptedClient)
+	var _ ClientForceUnlocker = new(EncryptedClient)
+}
+
+func TestEncryptedClient(t *testing.T) {
+	inner := new(InmemClient)
+	client := &EncryptedClient{
+		Client: inner,
+		Key:    []byte("01234567890123456789012345678901"),
+	}
+
+	data := []byte(`{"version": 3, "serial": 1}`)
+	if err := client.Put(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if bytes.Contains(inner.Data, []byte("serial")) {
+		t.Fatalf("state should be stored encrypted: %s", inner.Data)
+	}
+
+	p, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(p.Data, data) {
+		t.Fatalf("bad: %s", p.Data)
+	}
+
+	// A different key can't read it
+	other := &EncryptedClient{
+		Client: inner,
+		Key:    []byte("10987654321098765432109876543210"),
+	}
+	if _, err := other.Get(); err == nil {
+		t.Fatal("expected error decrypting with the wrong key")
+	}
+}
+
+func TestEncryptedClient_plaintext(t *testing.T) {
+	data := []byte(`{"version": 3, "serial": 1}`)
+	inner := new(InmemClient)
+	if err := inner.Put(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// With a key set, state that isn't encrypted is refused, so it can't
+	// be swapped in by someone without the key.
+	client := &EncryptedClient{
+		Client: inner,
+		Key:    []byte("01234567890123456789012345678901"),
+	}
+	if _, err := client.Get(); err == nil {
+		t.Fatal("expected error reading plaintext state")
+	}
+
+	// Unless reading it is allowed, to switch it to encryption
+	client.AllowPlaintext = true
+	p, err := client.Get()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !bytes.Equal(p.Data, data) {
+		t.Fatalf("bad: %s", p.Data)
+	}
+}
+
+func TestEncryptedClient_lock(t *testing.T) {
+	client := &EncryptedClient{Client: new(InmemClient)}
+
+	// Clients that can't lock are never locked
+	id, err := client.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestNewClient_encryption(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("01234567890123456789012345678901"))
+
+	os.Setenv(EncryptionKeyEnvVar, key)
+	defer os.Unsetenv(EncryptionKeyEnvVar)
+
+	client, err := NewClient("local", map[string]string{"path": "unused"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := client.(*EncryptedClient); !ok {
+		t.Fatalf("expected an EncryptedClient, got %T", client)
+	}
+
+	// The key can be read from a file
+	f, err := ioutil.TempFile("", "tf")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("c2hvcnQ=")
+	f.Close()
+
+	if _, err := NewClient("local", map[string]string{
+		"path":                      "unused",
+		"state_encryption_key_file": f.Name(),
+	}); err == nil || !strings.Contains(err.Error(), "got 40 bits") {
+		t.Fatalf("expected error for a short key, got: %v", err)
+	}
+
+	// The key itself can't be given, since the configuration is saved in
+	// plaintext, and neither can a file that doesn't exist or a KMS key
+	for _, conf := range []map[string]string{
+		{"path": "unused", "state_encryption_key": key},
+		{"path": "unused", "state_encryption_key_file": f.Name() + ".nope"},
+		{"path": "unused", "state_encryption_key_file": ""},
+		{"path": "unused", "state_encryption_kms_key_id": "alias/terraform"},
+	} {
+		if _, err := NewClient("local", conf); err == nil {
+			t.Fatalf("expected error for %#v", conf)
+		}
+	}
+
+	client, err = NewClient("local", map[string]string{
+		"path":                             "unused",
+		"state_encryption_allow_plaintext": "true",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !client.(*EncryptedClient).AllowPlaintext {
+		t.Fatal("expected plaintext to be allowed")
+	}
+}
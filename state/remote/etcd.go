@@ -2,13 +2,29 @@ package remote
 
 import (
 	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	etcdapi "github.com/coreos/etcd/client"
+	"github.com/hashicorp/terraform/state"
+	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
 )
 
+// defaultEtcdLockTTL is how long a lock is kept alive without being
+// refreshed, if lock_ttl isn't set.
+const defaultEtcdLockTTL = 60 * time.Second
+
 func etcdFactory(conf map[string]string) (Client, error) {
 	path, ok := conf["path"]
 	if !ok {
@@ -30,21 +46,94 @@ func etcdFactory(conf map[string]string) (Client, error) {
 		config.Password = password
 	}
 
+	tlsConfig, err := etcdTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		config.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			Dial: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 10 * time.Second,
+			TLSClientConfig:     tlsConfig,
+		}
+	}
+
+	lockTTL := defaultEtcdLockTTL
+	if raw, ok := conf["lock_ttl"]; ok && raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			return nil, fmt.Errorf("'lock_ttl' must be a number of seconds: %q", raw)
+		}
+		lockTTL = time.Duration(v) * time.Second
+	}
+
 	client, err := etcdapi.New(config)
 	if err != nil {
 		return nil, err
 	}
 
 	return &EtcdClient{
-		Client: client,
-		Path:   path,
+		Client:  client,
+		Path:    path,
+		LockTTL: lockTTL,
 	}, nil
 }
 
+// etcdTLSConfig returns the TLS configuration for the cacert_file,
+// cert_file and key_file settings, or nil if none are set.
+func etcdTLSConfig(conf map[string]string) (*tls.Config, error) {
+	cacertFile := conf["cacert_file"]
+	certFile := conf["cert_file"]
+	keyFile := conf["key_file"]
+	if cacertFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if cacertFile != "" {
+		caCert, err := ioutil.ReadFile(cacertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading 'cacert_file': %s", err)
+		}
+
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+		config.RootCAs = caCertPool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("'cert_file' and 'key_file' must be set together")
+		}
+
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading the client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
 // EtcdClient is a remote client that stores data in etcd.
 type EtcdClient struct {
 	Client etcdapi.Client
 	Path   string
+
+	// LockTTL is how long the lock lives if it isn't refreshed. While the
+	// lock is held it is refreshed in the background, so it only expires
+	// if this process dies. If zero, the lock never expires.
+	LockTTL time.Duration
+
+	// lockStop, guarded by lockMu, stops the refreshing of the lock we
+	// hold, if any.
+	lockMu   sync.Mutex
+	lockStop chan struct{}
 }
 
 func (c *EtcdClient) Get() (*Payload, error) {
@@ -76,3 +165,130 @@ func (c *EtcdClient) Delete() error {
 	_, err := etcdapi.NewKeysAPI(c.Client).Delete(context.Background(), c.Path, nil)
 	return err
 }
+
+// Lock creates the lock key, which must not already exist. The key has
+// a TTL of LockTTL and is refreshed until Unlock is called.
+func (c *EtcdClient) Lock(info *state.LockInfo) (string, error) {
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = c.Path
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+	value := string(raw)
+
+	_, err = etcdapi.NewKeysAPI(c.Client).Set(context.Background(), c.lockPath(), value,
+		&etcdapi.SetOptions{PrevExist: etcdapi.PrevNoExist, TTL: c.LockTTL})
+	if err != nil {
+		if err, ok := err.(etcdapi.Error); ok && err.Code == etcdapi.ErrorCodeNodeExist {
+			holder, _, _ := c.lockInfo()
+			return "", &state.LockError{Path: c.Path, Info: holder}
+		}
+
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+
+	if c.LockTTL > 0 {
+		stop := make(chan struct{})
+		c.lockMu.Lock()
+		c.lockStop = stop
+		c.lockMu.Unlock()
+
+		go c.refreshLock(value, stop)
+	}
+
+	return info.ID, nil
+}
+
+// refreshLock resets the TTL of the lock key holding value until stop is
+// closed. Each refresh only succeeds if the key still holds our lock.
+func (c *EtcdClient) refreshLock(value string, stop chan struct{}) {
+	ticker := time.NewTicker(c.LockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := etcdapi.NewKeysAPI(c.Client).Set(context.Background(), c.lockPath(), value,
+				&etcdapi.SetOptions{PrevValue: value, TTL: c.LockTTL})
+			if err != nil {
+				log.Printf("[WARN] Error refreshing the etcd state lock: %s", err)
+			}
+		}
+	}
+}
+
+// Unlock deletes the lock key if id matches the lock in it.
+func (c *EtcdClient) Unlock(id string) error {
+	info, value, err := c.lockInfo()
+	if err != nil {
+		return fmt.Errorf("Error reading the state lock: %s", err)
+	}
+	if info == nil {
+		return fmt.Errorf("state %s is not locked", c.Path)
+	}
+	if info.ID != id {
+		return fmt.Errorf("lock ID %q does not match the lock on state %s", id, c.Path)
+	}
+
+	c.lockMu.Lock()
+	if c.lockStop != nil {
+		close(c.lockStop)
+		c.lockStop = nil
+	}
+	c.lockMu.Unlock()
+
+	_, err = etcdapi.NewKeysAPI(c.Client).Delete(context.Background(), c.lockPath(),
+		&etcdapi.DeleteOptions{PrevValue: value})
+	if err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	return nil
+}
+
+// LockInfo returns the info of the current lock, or nil if the state
+// isn't locked.
+func (c *EtcdClient) LockInfo() (*state.LockInfo, error) {
+	info, _, err := c.lockInfo()
+	return info, err
+}
+
+// ForceUnlock removes the lock with the given ID. The lock is a key in
+// etcd, so Unlock already works from any process.
+func (c *EtcdClient) ForceUnlock(id string) error {
+	return c.Unlock(id)
+}
+
+// lockInfo reads the lock key, returning its info and raw value, or nil
+// if there is no lock.
+func (c *EtcdClient) lockInfo() (*state.LockInfo, string, error) {
+	resp, err := etcdapi.NewKeysAPI(c.Client).Get(context.Background(), c.lockPath(),
+		&etcdapi.GetOptions{Quorum: true})
+	if err != nil {
+		if err, ok := err.(etcdapi.Error); ok && err.Code == etcdapi.ErrorCodeKeyNotFound {
+			return nil, "", nil
+		}
+
+		return nil, "", err
+	}
+
+	info := new(state.LockInfo)
+	if err := json.Unmarshal([]byte(resp.Node.Value), info); err != nil {
+		return nil, "", err
+	}
+
+	return info, resp.Node.Value, nil
+}
+
+func (c *EtcdClient) lockPath() string {
+	return c.Path + ".tflock"
+}
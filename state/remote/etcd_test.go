@@ -5,10 +5,29 @@ import (
 	"os"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/terraform/state"
 )
 
 func TestEtcdClient_impl(t *testing.T) {
 	var _ Client = new(EtcdClient)
+	var _ ClientForceUnlocker = new(EtcdClient)
+}
+
+func TestEtcdFactory_config(t *testing.T) {
+	cases := map[string]map[string]string{
+		"bad lock_ttl": {"lock_ttl": "soon"},
+		"cert only":    {"cert_file": "cert.pem"},
+		"no cacert":    {"cacert_file": "does-not-exist.pem"},
+	}
+
+	for name, conf := range cases {
+		conf["path"] = "tf-unit"
+		conf["endpoints"] = "http://127.0.0.1:2379"
+		if _, err := etcdFactory(conf); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
 }
 
 func TestEtcdClient(t *testing.T) {
@@ -35,4 +54,33 @@ func TestEtcdClient(t *testing.T) {
 	}
 
 	testClient(t, client)
+
+	etcd := client.(*EtcdClient)
+	other := &EtcdClient{
+		Client:  etcd.Client,
+		Path:    etcd.Path,
+		LockTTL: etcd.LockTTL,
+	}
+
+	id, err := etcd.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := other.Lock(state.NewLockInfo()); err == nil {
+		t.Fatal("expected error locking a locked state")
+	}
+	if info, err := other.LockInfo(); err != nil || info == nil || info.ID != id {
+		t.Fatalf("bad: %#v %v", info, err)
+	}
+	if err := etcd.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	id, err = other.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := other.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 }
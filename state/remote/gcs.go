@@ -2,6 +2,9 @@ package remote
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,10 +12,13 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/terraform/helper/pathorcontents"
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/satori/go.uuid"
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -41,6 +47,17 @@ type GCSClient struct {
 	path          string
 	clientStorage *storage.Service
 	context       context.Context
+
+	// encryptionKey is the customer-supplied AES-256 key the state is
+	// encrypted with, if any.
+	encryptionKey []byte
+
+	// generation is the generation of the state object when it was last
+	// read or written, with 0 meaning it didn't exist. Put only replaces
+	// that generation, so that concurrent changes aren't lost. It is
+	// only used once generationKnown is set.
+	generation      int64
+	generationKnown bool
 }
 
 func gcsFactory(conf map[string]string) (Client, error) {
@@ -99,6 +116,29 @@ func gcsFactory(conf map[string]string) (Client, error) {
 			return nil, err
 		}
 	}
+
+	var encryptionKey []byte
+	key, ok := conf["encryption_key"]
+	if !ok {
+		key = os.Getenv("GOOGLE_ENCRYPTION_KEY")
+	}
+	if key != "" {
+		contents, _, err := pathorcontents.Read(key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading encryption_key: %s", err)
+		}
+
+		encryptionKey, err = base64.StdEncoding.DecodeString(strings.TrimSpace(contents))
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding encryption_key: %s", err)
+		}
+		if len(encryptionKey) != 32 {
+			return nil, fmt.Errorf(
+				"encryption_key must be a base64 encoded 256 bit key, got %d bits",
+				len(encryptionKey)*8)
+		}
+	}
+
 	versionString := terraform.Version
 	userAgent := fmt.Sprintf(
 		"(%s %s) Terraform/%s", runtime.GOOS, runtime.GOARCH, versionString)
@@ -114,6 +154,7 @@ func gcsFactory(conf map[string]string) (Client, error) {
 		clientStorage: clientStorage,
 		bucket:        bucketName,
 		path:          pathName,
+		encryptionKey: encryptionKey,
 	}, nil
 
 }
@@ -122,15 +163,19 @@ func (c *GCSClient) Get() (*Payload, error) {
 	// Read the object from bucket.
 	log.Printf("[INFO] Reading %s/%s", c.bucket, c.path)
 
-	resp, err := c.clientStorage.Objects.Get(c.bucket, c.path).Download()
+	call := c.clientStorage.Objects.Get(c.bucket, c.path)
+	c.setEncryptionHeaders(call.Header())
+	resp, err := call.Download()
 	if err != nil {
 		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
 			log.Printf("[INFO] %s/%s not found", c.bucket, c.path)
 
+			c.generation, c.generationKnown = 0, true
 			return nil, nil
 		}
 
-		return nil, fmt.Errorf("[WARN] Error retrieving object %s/%s: %s", c.bucket, c.path, err)
+		return nil, gcsError(err, fmt.Errorf(
+			"[WARN] Error retrieving object %s/%s: %s", c.bucket, c.path, err))
 	}
 	defer resp.Body.Close()
 
@@ -142,6 +187,10 @@ func (c *GCSClient) Get() (*Payload, error) {
 	}
 	log.Printf("[INFO] Downloaded %d bytes", n)
 
+	if gen, err := strconv.ParseInt(resp.Header.Get("X-Goog-Generation"), 10, 64); err == nil {
+		c.generation, c.generationKnown = gen, true
+	}
+
 	payload := &Payload{
 		Data: w.Bytes(),
 	}
@@ -158,18 +207,183 @@ func (c *GCSClient) Put(data []byte) error {
 	log.Printf("[INFO] Writing %s/%s", c.bucket, c.path)
 
 	r := bytes.NewReader(data)
-	_, err := c.clientStorage.Objects.Insert(c.bucket, &storage.Object{Name: c.path}).Media(r).Do()
+	call := c.clientStorage.Objects.Insert(c.bucket, &storage.Object{Name: c.path}).Media(r)
+	if c.generationKnown {
+		call = call.IfGenerationMatch(c.generation)
+	}
+	c.setEncryptionHeaders(call.Header())
+
+	obj, err := call.Do()
 	if err != nil {
-		return err
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusPreconditionFailed {
+			// If this Put is being retried, an earlier attempt may have
+			// written the object and lost the response, moving the
+			// generation on from under us.
+			if c.storedMatches(data) {
+				return nil
+			}
+
+			return &state.RemoteConflictError{
+				Path:   fmt.Sprintf("%s/%s", c.bucket, c.path),
+				Reason: "its generation no longer matches",
+			}
+		}
+
+		return gcsError(err, err)
 	}
 
+	c.generation, c.generationKnown = obj.Generation, true
 	return nil
 }
 
+// storedMatches returns true if the state object already holds data,
+// updating the generation to the stored one if so.
+func (c *GCSClient) storedMatches(data []byte) bool {
+	call := c.clientStorage.Objects.Get(c.bucket, c.path)
+	c.setEncryptionHeaders(call.Header())
+	obj, err := call.Do()
+	if err != nil {
+		return false
+	}
+
+	sum := md5.Sum(data)
+	if obj.Md5Hash != base64.StdEncoding.EncodeToString(sum[:]) {
+		return false
+	}
+
+	log.Printf("[INFO] %s/%s already holds the state being written", c.bucket, c.path)
+	c.generation, c.generationKnown = obj.Generation, true
+	return true
+}
+
+// gcsError returns msg, an error describing err, marked as a
+// state.TransientError if err is a server error or throttling response.
+func gcsError(err, msg error) error {
+	if gerr, ok := err.(*googleapi.Error); ok && state.RetryableStatus(gerr.Code) {
+		return &state.TransientError{Err: msg}
+	}
+
+	return retryable(err, msg)
+}
+
 func (c *GCSClient) Delete() error {
 	log.Printf("[INFO] Deleting %s/%s", c.bucket, c.path)
 
 	err := c.clientStorage.Objects.Delete(c.bucket, c.path).Do()
+	if err == nil {
+		c.generation, c.generationKnown = 0, true
+	}
 	return err
 
 }
+
+// Lock takes a lock on the state by creating the object "<path>.tflock"
+// with the lock info, on the condition that it doesn't exist yet.
+func (c *GCSClient) Lock(info *state.LockInfo) (string, error) {
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = fmt.Sprintf("%s/%s", c.bucket, c.path)
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = c.clientStorage.Objects.Insert(c.bucket, &storage.Object{Name: c.lockPath()}).
+		Media(bytes.NewReader(raw)).
+		IfGenerationMatch(0).
+		Do()
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusPreconditionFailed {
+			holder, _, _ := c.lockInfo()
+			return "", &state.LockError{Path: info.Path, Info: holder}
+		}
+
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+
+	return info.ID, nil
+}
+
+// Unlock releases the lock if id matches it. The lock object is only
+// deleted if it is still the generation that was checked.
+func (c *GCSClient) Unlock(id string) error {
+	info, gen, err := c.lockInfo()
+	if err != nil {
+		return fmt.Errorf("Error reading the state lock: %s", err)
+	}
+	if info == nil {
+		return fmt.Errorf("state %s/%s is not locked", c.bucket, c.path)
+	}
+	if info.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s/%s", id, c.bucket, c.path)
+	}
+
+	err = c.clientStorage.Objects.Delete(c.bucket, c.lockPath()).IfGenerationMatch(gen).Do()
+	if err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	return nil
+}
+
+// LockInfo returns the info of the current lock, or nil if the state
+// isn't locked.
+func (c *GCSClient) LockInfo() (*state.LockInfo, error) {
+	info, _, err := c.lockInfo()
+	return info, err
+}
+
+// ForceUnlock removes the lock with the given ID. The lock is an object
+// in the bucket, so Unlock already works from any process.
+func (c *GCSClient) ForceUnlock(id string) error {
+	return c.Unlock(id)
+}
+
+// lockInfo reads the lock object, returning its info and generation, or
+// nil if there is no lock.
+func (c *GCSClient) lockInfo() (*state.LockInfo, int64, error) {
+	resp, err := c.clientStorage.Objects.Get(c.bucket, c.lockPath()).Download()
+	if err != nil {
+		if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == 404 {
+			return nil, 0, nil
+		}
+
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	gen, err := strconv.ParseInt(resp.Header.Get("X-Goog-Generation"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("lock object has no generation: %s", err)
+	}
+
+	info := new(state.LockInfo)
+	if err := json.NewDecoder(resp.Body).Decode(info); err != nil {
+		return nil, 0, err
+	}
+
+	return info, gen, nil
+}
+
+func (c *GCSClient) lockPath() string {
+	return c.path + ".tflock"
+}
+
+// setEncryptionHeaders adds the headers for a customer-supplied
+// encryption key to h, if we have one.
+func (c *GCSClient) setEncryptionHeaders(h http.Header) {
+	if c.encryptionKey == nil {
+		return
+	}
+
+	hash := sha256.Sum256(c.encryptionKey)
+	h.Set("x-goog-encryption-algorithm", "AES256")
+	h.Set("x-goog-encryption-key", base64.StdEncoding.EncodeToString(c.encryptionKey))
+	h.Set("x-goog-encryption-key-sha256", base64.StdEncoding.EncodeToString(hash[:]))
+}
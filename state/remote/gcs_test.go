@@ -1,16 +1,95 @@
 package remote
 
 import (
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform/state"
 	storage "google.golang.org/api/storage/v1"
 )
 
 func TestGCSClient_impl(t *testing.T) {
 	var _ Client = new(GCSClient)
+	var _ ClientForceUnlocker = new(GCSClient)
+}
+
+func TestGCSClient_encryptionHeaders(t *testing.T) {
+	h := make(http.Header)
+	c := &GCSClient{}
+	c.setEncryptionHeaders(h)
+	if len(h) != 0 {
+		t.Fatalf("no headers expected without a key: %#v", h)
+	}
+
+	c.encryptionKey = []byte("01234567890123456789012345678901")
+	c.setEncryptionHeaders(h)
+	expected := map[string]string{
+		"X-Goog-Encryption-Algorithm":  "AES256",
+		"X-Goog-Encryption-Key":        "MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTIzNDU2Nzg5MDE=",
+		"X-Goog-Encryption-Key-Sha256": "hhAJ7E1Zn6sfQKvHbm+JiAz/WDPHnFSMmfkEXxkc2Qs=",
+	}
+	for k, v := range expected {
+		if actual := h.Get(k); actual != v {
+			t.Fatalf("bad %s: %s", k, actual)
+		}
+	}
+}
+
+func TestGCSClient_putConflict(t *testing.T) {
+	// The stored object has moved on to a newer generation, holding
+	// stored.
+	stored := []byte(`{"serial": 2}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "POST":
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `{"error": {"code": 412, "message": "Precondition Failed"}}`)
+		case "GET":
+			sum := md5.Sum(stored)
+			fmt.Fprintf(w, `{"generation": "7", "md5Hash": %q}`,
+				base64.StdEncoding.EncodeToString(sum[:]))
+		}
+	}))
+	defer ts.Close()
+
+	svc, err := storage.New(http.DefaultClient)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	svc.BasePath = ts.URL + "/"
+
+	c := &GCSClient{
+		bucket:          "bucket",
+		path:            "state",
+		clientStorage:   svc,
+		generation:      5,
+		generationKnown: true,
+	}
+
+	// Someone else wrote the object, so this is a conflict that isn't
+	// worth retrying.
+	err = c.Put([]byte(`{"serial": 1}`))
+	if _, ok := err.(*state.RemoteConflictError); !ok {
+		t.Fatalf("expected RemoteConflictError, got %#v", err)
+	}
+	if state.RetryableError(err) {
+		t.Fatal("conflict should not be retryable")
+	}
+
+	// The object already holds what we're writing, as it would if an
+	// earlier attempt of this Put succeeded without us hearing back.
+	if err := c.Put(stored); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.generation != 7 {
+		t.Fatalf("bad generation: %d", c.generation)
+	}
 }
 
 func TestGCSClient(t *testing.T) {
@@ -5,11 +5,16 @@ import (
 	"crypto/md5"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strconv"
+
+	"github.com/hashicorp/terraform/state"
+	"github.com/satori/go.uuid"
 )
 
 func httpFactory(conf map[string]string) (Client, error) {
@@ -44,20 +49,98 @@ func httpFactory(conf map[string]string) (Client, error) {
 		}
 	}
 
+	lockURL, err := httpOptionalURL(conf, "lock_address")
+	if err != nil {
+		return nil, err
+	}
+	unlockURL, err := httpOptionalURL(conf, "unlock_address")
+	if err != nil {
+		return nil, err
+	}
+
+	lockMethod := conf["lock_method"]
+	if lockMethod == "" {
+		lockMethod = "LOCK"
+	}
+	unlockMethod := conf["unlock_method"]
+	if unlockMethod == "" {
+		unlockMethod = "UNLOCK"
+	}
+
 	return &HTTPClient{
-		URL:    url,
-		Client: client,
+		URL:          url,
+		Client:       client,
+		Username:     conf["username"],
+		Password:     conf["password"],
+		LockURL:      lockURL,
+		UnlockURL:    unlockURL,
+		LockMethod:   lockMethod,
+		UnlockMethod: unlockMethod,
 	}, nil
 }
 
+// httpOptionalURL parses the URL in conf[key], returning nil if unset.
+func httpOptionalURL(conf map[string]string, key string) (*url.URL, error) {
+	raw, ok := conf[key]
+	if !ok || raw == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", key, err)
+	}
+
+	return u, nil
+}
+
 // HTTPClient is a remote client that stores data in Consul or HTTP REST.
 type HTTPClient struct {
 	URL    *url.URL
 	Client *http.Client
+
+	// Username and Password, if Username is set, are sent with every
+	// request using HTTP basic auth.
+	Username string
+	Password string
+
+	// LockURL and UnlockURL are where the state is locked and unlocked,
+	// using LockMethod and UnlockMethod. The request body is the JSON
+	// encoded state.LockInfo. If LockURL is nil, locking does nothing.
+	// If UnlockURL is nil, LockURL is used for both.
+	LockURL      *url.URL
+	UnlockURL    *url.URL
+	LockMethod   string
+	UnlockMethod string
+
+	// lockInfo is the info of the lock we hold, sent again on unlock.
+	lockInfo *state.LockInfo
+}
+
+// request makes an HTTP request with the client's auth.
+func (c *HTTPClient) request(method string, u *url.URL, data []byte) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to make HTTP request: %s", err)
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = int64(len(data))
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	return c.Client.Do(req)
 }
 
 func (c *HTTPClient) Get() (*Payload, error) {
-	resp, err := c.Client.Get(c.URL.String())
+	resp, err := c.request("GET", c.URL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -76,15 +159,18 @@ func (c *HTTPClient) Get() (*Payload, error) {
 	case http.StatusForbidden:
 		return nil, fmt.Errorf("HTTP remote state endpoint invalid auth")
 	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("HTTP remote state internal server error")
+		return nil, &state.TransientError{
+			Err: fmt.Errorf("HTTP remote state internal server error"),
+		}
 	default:
-		return nil, fmt.Errorf("Unexpected HTTP response code %d", resp.StatusCode)
+		return nil, httpStatusError(resp.StatusCode,
+			fmt.Errorf("Unexpected HTTP response code %d", resp.StatusCode))
 	}
 
 	// Read in the body
 	buf := bytes.NewBuffer(nil)
 	if _, err := io.Copy(buf, resp.Body); err != nil {
-		return nil, fmt.Errorf("Failed to read remote state: %s", err)
+		return nil, retryable(err, fmt.Errorf("Failed to read remote state: %s", err))
 	}
 
 	// Create the payload
@@ -105,6 +191,14 @@ func (c *HTTPClient) Get() (*Payload, error) {
 				"Failed to decode Content-MD5 '%s': %s", raw, err)
 		}
 
+		// Validate that we got the payload the server meant to send
+		hash := md5Sum(payload.Data)
+		if !bytes.Equal(hash, md5) {
+			return nil, fmt.Errorf(
+				"Remote state payload doesn't match its Content-MD5 header; it " +
+					"may have been corrupted in transit")
+		}
+
 		payload.MD5 = md5
 	} else {
 		// Generate the MD5
@@ -141,11 +235,14 @@ func (c *HTTPClient) Put(data []byte) error {
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Content-MD5", b64)
 	req.ContentLength = int64(len(data))
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
 
 	// Make the request
 	resp, err := c.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Failed to upload state: %v", err)
+		return retryable(err, fmt.Errorf("Failed to upload state: %v", err))
 	}
 	defer resp.Body.Close()
 
@@ -154,18 +251,13 @@ func (c *HTTPClient) Put(data []byte) error {
 	case http.StatusOK:
 		return nil
 	default:
-		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
+		return httpStatusError(resp.StatusCode,
+			fmt.Errorf("HTTP error: %d", resp.StatusCode))
 	}
 }
 
 func (c *HTTPClient) Delete() error {
-	req, err := http.NewRequest("DELETE", c.URL.String(), nil)
-	if err != nil {
-		return fmt.Errorf("Failed to make HTTP request: %s", err)
-	}
-
-	// Make the request
-	resp, err := c.Client.Do(req)
+	resp, err := c.request("DELETE", c.URL, nil)
 	if err != nil {
 		return fmt.Errorf("Failed to delete state: %s", err)
 	}
@@ -179,3 +271,110 @@ func (c *HTTPClient) Delete() error {
 		return fmt.Errorf("HTTP error: %d", resp.StatusCode)
 	}
 }
+
+// Lock locks the state by sending LockMethod to LockURL with the lock
+// info. The server should reply 200 if the lock was taken, or 409 or 423
+// if it is already locked, optionally with the holder's lock info as the
+// body.
+func (c *HTTPClient) Lock(info *state.LockInfo) (string, error) {
+	if c.LockURL == nil {
+		return "", nil
+	}
+	if c.lockInfo != nil {
+		return "", fmt.Errorf("state %s is already locked", c.URL)
+	}
+
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = c.URL.String()
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.request(c.LockMethod, c.LockURL, raw)
+	if err != nil {
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		c.lockInfo = info
+		return info.ID, nil
+	case http.StatusConflict, http.StatusLocked:
+		lockErr := &state.LockError{Path: c.URL.String()}
+		body, err := ioutil.ReadAll(resp.Body)
+		holder := new(state.LockInfo)
+		if err == nil && json.Unmarshal(body, holder) == nil && holder.ID != "" {
+			lockErr.Info = holder
+		}
+
+		return "", lockErr
+	case http.StatusUnauthorized:
+		return "", fmt.Errorf("HTTP remote state endpoint requires auth")
+	case http.StatusForbidden:
+		return "", fmt.Errorf("HTTP remote state endpoint invalid auth")
+	default:
+		return "", fmt.Errorf("Unexpected HTTP response code %d", resp.StatusCode)
+	}
+}
+
+// Unlock unlocks the state by sending UnlockMethod to UnlockURL with the
+// lock info that was sent to Lock.
+func (c *HTTPClient) Unlock(id string) error {
+	if c.LockURL == nil {
+		return nil
+	}
+	if c.lockInfo == nil {
+		return fmt.Errorf("state %s is not locked", c.URL)
+	}
+	if c.lockInfo.ID != id {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %s", id, c.URL)
+	}
+
+	raw, err := json.Marshal(c.lockInfo)
+	if err != nil {
+		return err
+	}
+
+	u := c.UnlockURL
+	if u == nil {
+		u = c.LockURL
+	}
+
+	resp, err := c.request(c.UnlockMethod, u, raw)
+	if err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		c.lockInfo = nil
+		return nil
+	default:
+		return fmt.Errorf("Unexpected HTTP response code %d", resp.StatusCode)
+	}
+}
+
+func md5Sum(data []byte) []byte {
+	hash := md5.Sum(data)
+	return hash[:]
+}
+
+// httpStatusError marks err, the error for a response with the given
+// status code, as transient if the status is one worth retrying.
+func httpStatusError(code int, err error) error {
+	if state.RetryableStatus(code) {
+		return &state.TransientError{Err: err}
+	}
+
+	return err
+}
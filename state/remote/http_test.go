@@ -7,13 +7,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/terraform/state"
 )
 
 func TestHTTPClient_impl(t *testing.T) {
 	var _ Client = new(HTTPClient)
+	var _ ClientLocker = new(HTTPClient)
 }
 
 func TestHTTPClient(t *testing.T) {
@@ -30,11 +33,122 @@ func TestHTTPClient(t *testing.T) {
 	testClient(t, client)
 }
 
+func TestHTTPClient_lock(t *testing.T) {
+	handler := new(testHTTPHandler)
+	ts := httptest.NewServer(http.HandlerFunc(handler.Handle))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	newClient := func() *HTTPClient {
+		return &HTTPClient{
+			URL:          u,
+			Client:       cleanhttp.DefaultClient(),
+			Username:     "user",
+			Password:     "pass",
+			LockURL:      u,
+			LockMethod:   "LOCK",
+			UnlockMethod: "UNLOCK",
+		}
+	}
+
+	a, b := newClient(), newClient()
+	id, err := a.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !handler.Auth {
+		t.Fatal("expected basic auth to be sent")
+	}
+
+	if _, err := b.Lock(state.NewLockInfo()); err == nil {
+		t.Fatal("expected error locking a locked state")
+	} else if !strings.Contains(err.Error(), id) {
+		t.Fatalf("expected the holder's lock ID in the error, got: %s", err)
+	}
+
+	if err := a.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := b.Lock(state.NewLockInfo()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestHTTPClient_retryable(t *testing.T) {
+	status := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	client := &HTTPClient{URL: u, Client: cleanhttp.DefaultClient()}
+
+	cases := []struct {
+		Status    int
+		Retryable bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusForbidden, false},
+		{http.StatusConflict, false},
+	}
+
+	for _, tc := range cases {
+		status = tc.Status
+
+		_, err := client.Get()
+		if err == nil || state.RetryableError(err) != tc.Retryable {
+			t.Fatalf("%d: bad get error: %v", tc.Status, err)
+		}
+
+		err = client.Put([]byte("{}"))
+		if err == nil || state.RetryableError(err) != tc.Retryable {
+			t.Fatalf("%d: bad put error: %v", tc.Status, err)
+		}
+	}
+}
+
+func TestHTTPClient_badMD5(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==")
+			w.Write([]byte(`{"version": 3}`))
+		}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	client := &HTTPClient{URL: u, Client: cleanhttp.DefaultClient()}
+	if _, err := client.Get(); err == nil {
+		t.Fatal("expected error for a payload not matching its Content-MD5")
+	}
+}
+
 type testHTTPHandler struct {
 	Data []byte
+
+	// Lock is the body of the current lock request, if locked, and Auth
+	// is whether the last request had the expected basic auth.
+	Lock []byte
+	Auth bool
 }
 
 func (h *testHTTPHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	user, pass, _ := r.BasicAuth()
+	h.Auth = user == "user" && pass == "pass"
+
 	switch r.Method {
 	case "GET":
 		w.Write(h.Data)
@@ -48,6 +162,21 @@ func (h *testHTTPHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		h.Data = nil
 		w.WriteHeader(200)
+	case "LOCK":
+		if h.Lock != nil {
+			w.WriteHeader(http.StatusLocked)
+			w.Write(h.Lock)
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		if _, err := io.Copy(buf, r.Body); err != nil {
+			w.WriteHeader(500)
+		}
+
+		h.Lock = buf.Bytes()
+	case "UNLOCK":
+		h.Lock = nil
 	default:
 		w.WriteHeader(500)
 		w.Write([]byte(fmt.Sprintf("Unknown method: %s", r.Method)))
@@ -0,0 +1,201 @@
+package remote
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/hashicorp/terraform/state"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+)
+
+func pgFactory(conf map[string]string) (Client, error) {
+	connStr, ok := conf["conn_str"]
+	if !ok {
+		return nil, fmt.Errorf("missing 'conn_str' configuration")
+	}
+
+	schemaName := conf["schema_name"]
+	if schemaName == "" {
+		schemaName = "terraform_remote_state"
+	}
+
+	name := conf["name"]
+	if name == "" {
+		name = "default"
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to Postgres: %s", err)
+	}
+
+	schema := pq.QuoteIdentifier(schemaName)
+	queries := []string{
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s`, schema),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.states (
+			name TEXT PRIMARY KEY,
+			data BYTEA NOT NULL,
+			serial BIGINT NOT NULL
+		)`, schema),
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("Error creating the state table: %s", err)
+		}
+	}
+
+	return &PostgresClient{
+		DB:         db,
+		SchemaName: schemaName,
+		Name:       name,
+	}, nil
+}
+
+// PostgresClient is a remote client that stores state in the "states"
+// table of a Postgres schema, one row per state name. Version 9.5 or
+// later of Postgres is required.
+//
+// Writes are rejected if the stored state has a newer serial, and the
+// state is locked with a transaction-level advisory lock.
+type PostgresClient struct {
+	DB         *sql.DB
+	SchemaName string
+	Name       string
+
+	// lockTx is the transaction holding the advisory lock, and lockID
+	// is the ID the lock was taken with.
+	lockTx *sql.Tx
+	lockID string
+}
+
+func (c *PostgresClient) Get() (*Payload, error) {
+	var data []byte
+	err := c.DB.QueryRow(fmt.Sprintf(
+		`SELECT data FROM %s.states WHERE name = $1`, c.table()),
+		c.Name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	md5 := md5.Sum(data)
+	return &Payload{
+		Data: data,
+		MD5:  md5[:],
+	}, nil
+}
+
+func (c *PostgresClient) Put(data []byte) error {
+	serial, err := stateSerial(data)
+	if err != nil {
+		return err
+	}
+
+	return c.PutSerial(data, serial)
+}
+
+// PutSerial stores data with the given serial, unless the stored state
+// has a newer serial.
+//
+// ClientSerialPutter impl.
+func (c *PostgresClient) PutSerial(data []byte, serial int64) error {
+	res, err := c.DB.Exec(fmt.Sprintf(
+		`INSERT INTO %[1]s.states (name, data, serial) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE
+		SET data = EXCLUDED.data, serial = EXCLUDED.serial
+		WHERE %[1]s.states.serial <= EXCLUDED.serial`, c.table()),
+		c.Name, data, serial)
+	if err != nil {
+		return retryable(err, fmt.Errorf("Failed to upload state: %s", err))
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return &state.RemoteConflictError{
+			Path:   c.Name,
+			Reason: fmt.Sprintf("the stored state is newer than serial %d", serial),
+		}
+	}
+
+	return nil
+}
+
+func (c *PostgresClient) Delete() error {
+	_, err := c.DB.Exec(fmt.Sprintf(
+		`DELETE FROM %s.states WHERE name = $1`, c.table()), c.Name)
+	return err
+}
+
+// Lock takes a transaction-level advisory lock on the state, keeping the
+// transaction open until Unlock. Postgres releases the lock if the
+// connection is lost, so it can't outlive a crashed run.
+func (c *PostgresClient) Lock(info *state.LockInfo) (string, error) {
+	if c.lockTx != nil {
+		return "", fmt.Errorf("state %q is already locked", c.Name)
+	}
+
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = c.Name
+
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+
+	var locked bool
+	err = tx.QueryRow(
+		`SELECT pg_try_advisory_xact_lock($1)`, c.lockKey()).Scan(&locked)
+	if err != nil {
+		tx.Rollback()
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+	if !locked {
+		tx.Rollback()
+		return "", &state.LockError{Path: c.Name}
+	}
+
+	c.lockTx = tx
+	c.lockID = info.ID
+	return info.ID, nil
+}
+
+// Unlock releases the lock taken by Lock if id matches it.
+func (c *PostgresClient) Unlock(id string) error {
+	if c.lockTx == nil {
+		return fmt.Errorf("state %q is not locked", c.Name)
+	}
+	if id != c.lockID {
+		return fmt.Errorf(
+			"lock ID %q does not match the lock on state %q", id, c.Name)
+	}
+
+	err := c.lockTx.Rollback()
+	c.lockTx = nil
+	c.lockID = ""
+	if err != nil {
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	return nil
+}
+
+func (c *PostgresClient) table() string {
+	return pq.QuoteIdentifier(c.SchemaName)
+}
+
+// lockKey returns the advisory lock key for the state.
+func (c *PostgresClient) lockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(c.SchemaName + "." + c.Name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,160 @@
+package remote
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/state"
+)
+
+func init() {
+	sql.Register("tf-pg-test", testPGDriver)
+}
+
+func TestPostgresClient_impl(t *testing.T) {
+	var _ Client = new(PostgresClient)
+	var _ ClientLocker = new(PostgresClient)
+	var _ ClientSerialPutter = new(PostgresClient)
+}
+
+func TestPostgresClient_encrypted(t *testing.T) {
+	testPGDriver.reset()
+	db, err := sql.Open("tf-pg-test", "")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer db.Close()
+
+	client := &EncryptedClient{
+		Client: &PostgresClient{DB: db, SchemaName: "test", Name: "default"},
+		Key:    []byte("01234567890123456789012345678901"),
+	}
+
+	// The serial of the plaintext state is stored, not that of the
+	// encrypted envelope, which has none
+	if err := client.Put([]byte(`{"version": 3, "serial": 5}`)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if testPGDriver.serial != 5 {
+		t.Fatalf("bad serial: %d", testPGDriver.serial)
+	}
+
+	// So an older state is still refused
+	err = client.Put([]byte(`{"version": 3, "serial": 3}`))
+	if _, ok := err.(*state.RemoteConflictError); !ok {
+		t.Fatalf("expected a conflict, got: %v", err)
+	}
+}
+
+func TestPostgresClient(t *testing.T) {
+	// This test needs a Postgres database it can create a schema in, so
+	// it only runs when a connection string is given.
+	connStr := os.Getenv("TF_PG_TEST")
+	if connStr == "" {
+		t.Skipf("skipping; TF_PG_TEST must be set to a Postgres connection string")
+	}
+
+	client, err := pgFactory(map[string]string{
+		"conn_str":    connStr,
+		"schema_name": fmt.Sprintf("terraform_remote_state_test_%d", time.Now().Unix()),
+	})
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	testClient(t, client)
+
+	pg := client.(*PostgresClient)
+	other := &PostgresClient{
+		DB:         pg.DB,
+		SchemaName: pg.SchemaName,
+		Name:       pg.Name,
+	}
+
+	id, err := pg.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := other.Lock(state.NewLockInfo()); err == nil {
+		t.Fatal("expected error locking a locked state")
+	}
+	if err := pg.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := other.Lock(state.NewLockInfo()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+// testPGDriver is a database/sql driver that stands in for Postgres in
+// tests of PostgresClient.Put. It keeps a single row, and applies the
+// serial guard of the upsert to it.
+var testPGDriver = new(pgTestDriver)
+
+type pgTestDriver struct {
+	stored bool
+	data   []byte
+	serial int64
+}
+
+func (d *pgTestDriver) reset() {
+	*d = pgTestDriver{}
+}
+
+func (d *pgTestDriver) Open(string) (driver.Conn, error) {
+	return &pgTestConn{d: d}, nil
+}
+
+type pgTestConn struct {
+	d *pgTestDriver
+}
+
+func (c *pgTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &pgTestStmt{d: c.d}, nil
+}
+
+func (c *pgTestConn) Close() error {
+	return nil
+}
+
+func (c *pgTestConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions aren't supported")
+}
+
+type pgTestStmt struct {
+	d *pgTestDriver
+}
+
+func (s *pgTestStmt) Close() error {
+	return nil
+}
+
+func (s *pgTestStmt) NumInput() int {
+	return -1
+}
+
+// Exec runs the upsert of PostgresClient.PutSerial, whose arguments are
+// the name, data and serial.
+func (s *pgTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("unexpected query arguments: %#v", args)
+	}
+
+	serial := args[2].(int64)
+	if s.d.stored && s.d.serial > serial {
+		return driver.RowsAffected(0), nil
+	}
+
+	s.d.stored = true
+	s.d.data = args[1].([]byte)
+	s.d.serial = serial
+	return driver.RowsAffected(1), nil
+}
+
+func (s *pgTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, fmt.Errorf("queries aren't supported")
+}
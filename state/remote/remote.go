@@ -1,7 +1,10 @@
 package remote
 
 import (
+	"encoding/json"
 	"fmt"
+
+	"github.com/hashicorp/terraform/state"
 )
 
 // Client is the interface that must be implemented for a remote state
@@ -13,6 +16,31 @@ type Client interface {
 	Delete() error
 }
 
+// ClientLocker is an optional interface for clients that can also lock
+// the state they store. State uses it to implement state.Locker.
+type ClientLocker interface {
+	Client
+	state.Locker
+}
+
+// ClientForceUnlocker is an optional interface for lockers whose lock can
+// be inspected and removed by another process. State uses it to implement
+// state.ForceUnlocker.
+type ClientForceUnlocker interface {
+	ClientLocker
+	LockInfo() (*state.LockInfo, error)
+	ForceUnlock(id string) error
+}
+
+// ClientSerialPutter is an optional interface for clients that store the
+// serial of the state next to it. PutSerial stores data as the state with
+// the given serial, without reading the serial from data, so the serial
+// can be given by clients such as EncryptedClient whose data hides it.
+type ClientSerialPutter interface {
+	Client
+	PutSerial(data []byte, serial int64) error
+}
+
 // Payload is the return value from the remote state storage.
 type Payload struct {
 	MD5  []byte
@@ -24,13 +52,35 @@ type Factory func(map[string]string) (Client, error)
 
 // NewClient returns a new Client with the given type and configuration.
 // The client is looked up in the BuiltinClients variable.
+//
+// If the "state_encryption_key_file" configuration or EncryptionKeyEnvVar
+// is set, the client is wrapped in an EncryptedClient using that key. Setting
+// "state_encryption_allow_plaintext" lets it read unencrypted state.
 func NewClient(t string, conf map[string]string) (Client, error) {
 	f, ok := BuiltinClients[t]
 	if !ok {
 		return nil, fmt.Errorf("unknown remote client type: %s", t)
 	}
 
-	return f(conf)
+	key, err := encryptionKey(conf)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := allowPlaintext(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := f(conf)
+	if err != nil || key == nil {
+		return client, err
+	}
+
+	return &EncryptedClient{
+		Client:         client,
+		Key:            key,
+		AllowPlaintext: plaintext,
+	}, nil
 }
 
 // BuiltinClients is the list of built-in clients that can be used with
@@ -44,7 +94,31 @@ var BuiltinClients = map[string]Factory{
 	"gcs":         gcsFactory,
 	"http":        httpFactory,
 	"local":       fileFactory,
+	"pg":          pgFactory,
 	"s3":          s3Factory,
 	"swift":       swiftFactory,
 	"manta":       mantaFactory,
 }
+
+// retryable returns msg, an error describing err, marked as a
+// state.TransientError if err is worth retrying. Replacing err with a
+// message of our own would otherwise hide that from state.RetryState.
+func retryable(err, msg error) error {
+	if state.RetryableError(err) {
+		return &state.TransientError{Err: msg}
+	}
+
+	return msg
+}
+
+// stateSerial returns the serial of the encoded state data.
+func stateSerial(data []byte) (int64, error) {
+	var s struct {
+		Serial int64 `json:"serial"`
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("Error reading the serial of the state: %s", err)
+	}
+
+	return s.Serial, nil
+}
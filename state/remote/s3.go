@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -11,10 +12,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-multierror"
 	terraformAws "github.com/hashicorp/terraform/builtin/providers/aws"
+	"github.com/hashicorp/terraform/state"
+	"github.com/satori/go.uuid"
 )
 
 func s3Factory(conf map[string]string) (Client, error) {
@@ -58,6 +62,7 @@ func s3Factory(conf map[string]string) (Client, error) {
 		acl = raw
 	}
 	kmsKeyID := conf["kms_key_id"]
+	lockTable := conf["lock_table"]
 
 	var errs []error
 	creds, err := terraformAws.GetCredentials(&terraformAws.Config{
@@ -89,6 +94,7 @@ providing credentials for the AWS S3 remote`))
 	}
 	sess := session.New(awsConfig)
 	nativeClient := s3.New(sess)
+	dynClient := dynamodb.New(sess)
 
 	return &S3Client{
 		nativeClient:         nativeClient,
@@ -97,6 +103,8 @@ providing credentials for the AWS S3 remote`))
 		serverSideEncryption: serverSideEncryption,
 		acl:                  acl,
 		kmsKeyID:             kmsKeyID,
+		dynClient:            dynClient,
+		lockTable:            lockTable,
 	}, nil
 }
 
@@ -107,6 +115,8 @@ type S3Client struct {
 	serverSideEncryption bool
 	acl                  string
 	kmsKeyID             string
+	dynClient            *dynamodb.DynamoDB
+	lockTable            string
 }
 
 func (c *S3Client) Get() (*Payload, error) {
@@ -131,7 +141,7 @@ func (c *S3Client) Get() (*Payload, error) {
 
 	buf := bytes.NewBuffer(nil)
 	if _, err := io.Copy(buf, output.Body); err != nil {
-		return nil, fmt.Errorf("Failed to read remote state: %s", err)
+		return nil, retryable(err, fmt.Errorf("Failed to read remote state: %s", err))
 	}
 
 	payload := &Payload{
@@ -176,7 +186,7 @@ func (c *S3Client) Put(data []byte) error {
 	if _, err := c.nativeClient.PutObject(i); err == nil {
 		return nil
 	} else {
-		return fmt.Errorf("Failed to upload state: %v", err)
+		return retryable(err, fmt.Errorf("Failed to upload state: %v", err))
 	}
 }
 
@@ -188,3 +198,157 @@ func (c *S3Client) Delete() error {
 
 	return err
 }
+
+// Lock takes a lock on the state by creating an item in the DynamoDB
+// table named by lock_table. The table must have a string hash key
+// named "LockID". If no lock_table is configured, Lock does nothing.
+func (c *S3Client) Lock(info *state.LockInfo) (string, error) {
+	if c.lockTable == "" {
+		return "", nil
+	}
+
+	if info == nil {
+		info = state.NewLockInfo()
+	}
+	if info.ID == "" {
+		info.ID = uuid.NewV4().String()
+	}
+	info.Path = c.lockPath()
+
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = c.dynClient.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(c.lockTable),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(c.lockPath())},
+			"Info":   {S: aws.String(string(raw))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockID)"),
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
+			holder, _ := c.getLockInfo()
+			return "", &state.LockError{Path: c.lockPath(), Info: holder}
+		}
+
+		return "", fmt.Errorf("Error acquiring the state lock: %s", err)
+	}
+
+	return info.ID, nil
+}
+
+// Unlock releases the lock taken by Lock if id matches it. The delete is
+// conditional on the stored lock info, so a lock taken by someone else
+// after it was read is left in place.
+func (c *S3Client) Unlock(id string) error {
+	if c.lockTable == "" {
+		return nil
+	}
+
+	raw, err := c.getLockItem()
+	if err != nil {
+		return fmt.Errorf("Error reading the state lock: %s", err)
+	}
+	if raw == "" {
+		return fmt.Errorf("state %s is not locked", c.lockPath())
+	}
+	info, err := parseLockInfo(raw)
+	if err != nil {
+		return fmt.Errorf("Error reading the state lock: %s", err)
+	}
+	if info.ID != id {
+		return c.lockMismatchError(id)
+	}
+
+	_, err = c.dynClient.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(c.lockTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(c.lockPath())},
+		},
+		ConditionExpression: aws.String("Info = :info"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":info": {S: aws.String(raw)},
+		},
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ConditionalCheckFailedException" {
+			return c.lockMismatchError(id)
+		}
+
+		return fmt.Errorf("Error releasing the state lock: %s", err)
+	}
+
+	return nil
+}
+
+// lockMismatchError returns the error for an Unlock with an ID that
+// doesn't match the current lock.
+func (c *S3Client) lockMismatchError(id string) error {
+	return fmt.Errorf(
+		"lock ID %q does not match the lock on state %s", id, c.lockPath())
+}
+
+// LockInfo returns the info of the current lock, or nil if the state
+// isn't locked.
+func (c *S3Client) LockInfo() (*state.LockInfo, error) {
+	if c.lockTable == "" {
+		return nil, nil
+	}
+
+	return c.getLockInfo()
+}
+
+// ForceUnlock removes the lock with the given ID. The lock is stored in
+// DynamoDB, so Unlock already works from any process.
+func (c *S3Client) ForceUnlock(id string) error {
+	return c.Unlock(id)
+}
+
+// getLockInfo returns the info stored with the current lock, or nil if
+// the state isn't locked.
+func (c *S3Client) getLockInfo() (*state.LockInfo, error) {
+	raw, err := c.getLockItem()
+	if err != nil || raw == "" {
+		return nil, err
+	}
+
+	return parseLockInfo(raw)
+}
+
+// getLockItem returns the raw Info attribute of the current lock, or an
+// empty string if the state isn't locked.
+func (c *S3Client) getLockItem() (string, error) {
+	resp, err := c.dynClient.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(c.lockTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(c.lockPath())},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	v, ok := resp.Item["Info"]
+	if !ok || v.S == nil {
+		return "", nil
+	}
+
+	return *v.S, nil
+}
+
+func parseLockInfo(raw string) (*state.LockInfo, error) {
+	info := new(state.LockInfo)
+	if err := json.Unmarshal([]byte(raw), info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+func (c *S3Client) lockPath() string {
+	return fmt.Sprintf("%s/%s", c.bucketName, c.keyName)
+}
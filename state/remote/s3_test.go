@@ -1,8 +1,12 @@
 package remote
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -11,6 +15,7 @@ import (
 
 func TestS3Client_impl(t *testing.T) {
 	var _ Client = new(S3Client)
+	var _ ClientForceUnlocker = new(S3Client)
 }
 
 func TestS3Factory(t *testing.T) {
@@ -65,6 +70,69 @@ func TestS3Factory(t *testing.T) {
 	}
 }
 
+func TestS3Client_unlockChanged(t *testing.T) {
+	// A fake DynamoDB endpoint where the lock is replaced by another
+	// process right after Unlock reads it.
+	var stored string
+	var deleted bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			ConditionExpression       string
+			ExpressionAttributeValues map[string]struct{ S string }
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("bad request body: %s", err)
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		switch {
+		case strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".GetItem"):
+			item := stored
+			stored = `{"ID":"other"}`
+			fmt.Fprintf(w, `{"Item":{"Info":{"S":%q}}}`, item)
+		case strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".DeleteItem"):
+			if input.ConditionExpression != "" &&
+				input.ExpressionAttributeValues[":info"].S != stored {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException","message":"The conditional request failed"}`)
+				return
+			}
+			deleted = true
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Errorf("unexpected request: %s", r.Header.Get("X-Amz-Target"))
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	client, err := s3Factory(map[string]string{
+		"region":     "us-west-1",
+		"bucket":     "foo",
+		"key":        "bar",
+		"endpoint":   ts.URL,
+		"access_key": "bazkey",
+		"secret_key": "bazsecret",
+		"lock_table": "locks",
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	s3Client := client.(*S3Client)
+
+	stored = `{"ID":"mine"}`
+	err = s3Client.Unlock("mine")
+	if err == nil {
+		t.Fatal("expected an error unlocking a replaced lock")
+	}
+	if !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if deleted {
+		t.Fatal("the other process's lock was deleted")
+	}
+}
+
 func TestS3Client(t *testing.T) {
 	// This test creates a bucket in S3 and populates it.
 	// It may incur costs, so it will only run if AWS credential environment
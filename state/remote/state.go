@@ -2,7 +2,9 @@ package remote
 
 import (
 	"bytes"
+	"fmt"
 
+	"github.com/hashicorp/terraform/state"
 	"github.com/hashicorp/terraform/terraform"
 )
 
@@ -60,3 +62,41 @@ func (s *State) PersistState() error {
 
 	return s.Client.Put(buf.Bytes())
 }
+
+// Lock implements state.Locker. If the client doesn't implement
+// ClientLocker, locking always succeeds and does nothing.
+func (s *State) Lock(info *state.LockInfo) (string, error) {
+	if c, ok := s.Client.(ClientLocker); ok {
+		return c.Lock(info)
+	}
+
+	return "", nil
+}
+
+// Unlock implements state.Locker.
+func (s *State) Unlock(id string) error {
+	if c, ok := s.Client.(ClientLocker); ok {
+		return c.Unlock(id)
+	}
+
+	return nil
+}
+
+// LockInfo implements state.ForceUnlocker. If the client doesn't
+// implement ClientForceUnlocker, the state is never reported as locked.
+func (s *State) LockInfo() (*state.LockInfo, error) {
+	if c, ok := s.Client.(ClientForceUnlocker); ok {
+		return c.LockInfo()
+	}
+
+	return nil, nil
+}
+
+// ForceUnlock implements state.ForceUnlocker.
+func (s *State) ForceUnlock(id string) error {
+	if c, ok := s.Client.(ClientForceUnlocker); ok {
+		return c.ForceUnlock(id)
+	}
+
+	return fmt.Errorf("this remote state doesn't support force-unlocking")
+}
@@ -24,4 +24,17 @@ func TestState_impl(t *testing.T) {
 	var _ state.StateWriter = new(State)
 	var _ state.StatePersister = new(State)
 	var _ state.StateRefresher = new(State)
+	var _ state.ForceUnlocker = new(State)
+}
+
+func TestState_lockNoLocker(t *testing.T) {
+	s := &State{Client: new(InmemClient)}
+
+	id, err := s.Lock(state.NewLockInfo())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := s.Unlock(id); err != nil {
+		t.Fatalf("err: %s", err)
+	}
 }
@@ -0,0 +1,230 @@
+package state
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const (
+	// DefaultRetryAttempts is the number of attempts RetryState makes if
+	// Attempts isn't set.
+	DefaultRetryAttempts = 5
+
+	// DefaultRetryBackoff is the wait before the first retry if Backoff
+	// isn't set. It doubles after each attempt.
+	DefaultRetryBackoff = time.Second
+
+	// DefaultRetryMaxBackoff is the longest wait between attempts if
+	// MaxBackoff isn't set.
+	DefaultRetryMaxBackoff = 30 * time.Second
+)
+
+// RetryState wraps a State and retries RefreshState and PersistState when
+// they fail, waiting longer after each failed attempt. This keeps a
+// transient error, such as a dropped connection to remote storage, from
+// failing a run that has otherwise finished and losing its new state.
+//
+// Only errors that RetryableError recognizes as transient are retried.
+// Any other error, such as the state being locked, having been changed by
+// someone else or failing to parse, is returned straight away.
+type RetryState struct {
+	Real State
+
+	// Attempts is the total number of attempts made. If zero,
+	// DefaultRetryAttempts is used.
+	Attempts int
+
+	// Backoff is the wait before the first retry, which doubles after
+	// each attempt up to MaxBackoff. If zero, DefaultRetryBackoff and
+	// DefaultRetryMaxBackoff are used.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable decides whether err is worth retrying. If nil,
+	// RetryableError is used.
+	Retryable func(err error) bool
+}
+
+// StateReader impl.
+func (s *RetryState) State() *terraform.State {
+	return s.Real.State()
+}
+
+// StateRefresher impl.
+func (s *RetryState) RefreshState() error {
+	return s.retry("refresh", s.Real.RefreshState)
+}
+
+// StateWriter impl.
+func (s *RetryState) WriteState(state *terraform.State) error {
+	return s.Real.WriteState(state)
+}
+
+// StatePersister impl.
+func (s *RetryState) PersistState() error {
+	return s.retry("persist", s.Real.PersistState)
+}
+
+// Locker impl.
+func (s *RetryState) Lock(info *LockInfo) (string, error) {
+	return lockState(s.Real, info)
+}
+
+// Locker impl.
+func (s *RetryState) Unlock(id string) error {
+	return unlockState(s.Real, id)
+}
+
+// ForceUnlocker impl.
+func (s *RetryState) LockInfo() (*LockInfo, error) {
+	return stateLockInfo(s.Real)
+}
+
+// ForceUnlocker impl.
+func (s *RetryState) ForceUnlock(id string) error {
+	return forceUnlockState(s.Real, id)
+}
+
+func (s *RetryState) retry(op string, f func() error) error {
+	attempts := s.Attempts
+	if attempts <= 0 {
+		attempts = DefaultRetryAttempts
+	}
+	backoff, maxBackoff := s.Backoff, s.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+	retryable := s.Retryable
+	if retryable == nil {
+		retryable = RetryableError
+	}
+
+	var err error
+	for i := 1; ; i++ {
+		err = f()
+		if err == nil || i >= attempts || !retryable(err) {
+			return err
+		}
+
+		log.Printf(
+			"[WARN] state: %s failed (attempt %d of %d), retrying in %s: %s",
+			op, i, attempts, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// RetryableError is the default classification of RetryState. It returns
+// true only for errors that are likely to go away on their own: network
+// errors and timeouts, server errors and throttling responses from
+// storage APIs, and errors marked with TransientError. Everything else is
+// assumed to fail again the same way.
+func RetryableError(err error) bool {
+	if IsConflictError(err) {
+		return false
+	}
+
+	switch err.(type) {
+	case *TransientError:
+		return true
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	}
+
+	// API clients, such as the AWS SDK's, expose the status and error
+	// code of a failed request.
+	if e, ok := err.(interface {
+		StatusCode() int
+	}); ok && RetryableStatus(e.StatusCode()) {
+		return true
+	}
+	if e, ok := err.(interface {
+		Code() string
+	}); ok && retryableCodes[e.Code()] {
+		return true
+	}
+
+	return false
+}
+
+// RetryableStatus returns true if an HTTP response with status code
+// means the request may succeed if made again: a server error or a
+// request to slow down.
+func RetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// retryableCodes are the error codes that storage APIs use to throttle
+// requests, along with the AWS SDK's code for a request that couldn't be
+// sent at all.
+var retryableCodes = map[string]bool{
+	"RequestError":                           true,
+	"Throttling":                             true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"SlowDown":                               true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// TransientError marks Err as worth retrying by RetryState. Remote
+// clients use it for failures RetryableError can't recognize on its own,
+// such as a server error they've turned into a message of their own.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// RemoteConflictError is returned by a remote state client when the
+// stored state was changed since it was read, so writing over it would
+// lose those changes. It is never retried.
+//
+// The other errors for a stored state that can't safely be replaced,
+// StateConflictError, CacheConflictError and PushConflictError, embed a
+// RemoteConflictError, so IsConflictError recognizes all of them.
+type RemoteConflictError struct {
+	// Path identifies the stored state, such as "bucket/key". It is
+	// empty if the state has no path of its own.
+	Path string
+
+	// Reason says how the change was detected.
+	Reason string
+}
+
+func (e *RemoteConflictError) Error() string {
+	return fmt.Sprintf(
+		"remote state %s was changed since it was read (%s); refresh and try again",
+		e.Path, e.Reason)
+}
+
+// Conflict returns e. It is promoted to the errors that embed a
+// RemoteConflictError, so that they can be handled the same way.
+func (e *RemoteConflictError) Conflict() *RemoteConflictError {
+	return e
+}
+
+// IsConflictError returns true if err is a RemoteConflictError or embeds
+// one: the stored state changed in a way that replacing it would lose.
+func IsConflictError(err error) bool {
+	_, ok := err.(interface {
+		Conflict() *RemoteConflictError
+	})
+	return ok
+}
@@ -0,0 +1,154 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRetryState(t *testing.T) {
+	TestState(t, &RetryState{Real: &InmemState{state: TestStateInitial()}})
+}
+
+func TestRetryState_impl(t *testing.T) {
+	var _ StateReader = new(RetryState)
+	var _ StateWriter = new(RetryState)
+	var _ StatePersister = new(RetryState)
+	var _ StateRefresher = new(RetryState)
+	var _ ForceUnlocker = new(RetryState)
+}
+
+func TestRetryState_persist(t *testing.T) {
+	cases := map[string]struct {
+		Errs     []error
+		Err      bool
+		Attempts int
+	}{
+		"success": {
+			nil, false, 1,
+		},
+		"transient": {
+			[]error{
+				&TransientError{Err: errors.New("timeout")},
+				&url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("reset")},
+			},
+			false, 3,
+		},
+		"too many": {
+			[]error{
+				&TransientError{Err: errors.New("a")},
+				&TransientError{Err: errors.New("b")},
+				&TransientError{Err: errors.New("c")},
+			},
+			true, 3,
+		},
+		"permanent": {
+			[]error{&LockError{}}, true, 1,
+		},
+		"unknown": {
+			[]error{errors.New("Failed to check for magic bytes: EOF")}, true, 1,
+		},
+	}
+
+	for name, tc := range cases {
+		real := &failingState{InmemState: &InmemState{}, errs: tc.Errs}
+		s := &RetryState{
+			Real:     real,
+			Attempts: 3,
+			Backoff:  time.Millisecond,
+		}
+
+		err := s.PersistState()
+		if (err != nil) != tc.Err {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if real.attempts != tc.Attempts {
+			t.Fatalf("%s: expected %d attempts, got %d", name, tc.Attempts, real.attempts)
+		}
+	}
+}
+
+// failingState is an InmemState whose PersistState fails with each of
+// errs in turn before succeeding.
+type failingState struct {
+	*InmemState
+
+	errs     []error
+	attempts int
+}
+
+func (s *failingState) PersistState() error {
+	s.attempts++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+
+	return s.InmemState.PersistState()
+}
+
+func TestRetryableError(t *testing.T) {
+	cases := []struct {
+		Err      error
+		Expected bool
+	}{
+		{&TransientError{Err: errors.New("500")}, true},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("reset")}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("refused")}, true},
+		{testStatusError{status: 503}, true},
+		{testStatusError{status: 429}, true},
+		{testStatusError{status: 400, code: "Throttling"}, true},
+		{testStatusError{status: 403, code: "AccessDenied"}, false},
+		{testStatusError{status: 412}, false},
+		{errors.New("Failed to check for magic bytes: EOF"), false},
+		{fmt.Errorf("Error decrypting state: %s", errors.New("bad key")), false},
+		{&RemoteConflictError{Path: "bucket/key", Reason: "generation changed"}, false},
+		{&LockError{}, false},
+		{&StateConflictError{}, false},
+		{&CacheConflictError{}, false},
+		{&PushConflictError{}, false},
+		{ErrReadOnly, false},
+	}
+
+	for i, tc := range cases {
+		if actual := RetryableError(tc.Err); actual != tc.Expected {
+			t.Fatalf("%d: expected %t for %#v", i, tc.Expected, tc.Err)
+		}
+	}
+}
+
+func TestIsConflictError(t *testing.T) {
+	cases := []struct {
+		Err      error
+		Expected bool
+	}{
+		{&RemoteConflictError{Path: "bucket/key", Reason: "generation changed"}, true},
+		{&StateConflictError{}, true},
+		{&CacheConflictError{}, true},
+		{&PushConflictError{}, true},
+		{&LockError{}, false},
+		{errors.New("conflict"), false},
+		{nil, false},
+	}
+
+	for i, tc := range cases {
+		if actual := IsConflictError(tc.Err); actual != tc.Expected {
+			t.Fatalf("%d: expected %t for %#v", i, tc.Expected, tc.Err)
+		}
+	}
+}
+
+// testStatusError is an error with the status and error code of a failed
+// API request, like those of the AWS SDK.
+type testStatusError struct {
+	status int
+	code   string
+}
+
+func (e testStatusError) Error() string   { return fmt.Sprintf("%d %s", e.status, e.code) }
+func (e testStatusError) StatusCode() int { return e.status }
+func (e testStatusError) Code() string    { return e.code }
@@ -0,0 +1,361 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// StateScanner extracts a single module or resource from a JSON state
+// without decoding the rest of the state into memory. This is useful for
+// tooling that only needs a small part of a very large state file.
+//
+// Only the current state format (version 3) is supported. Unlike
+// terraform.ReadState, no upgrades or validation are performed on the
+// extracted values.
+type StateScanner struct {
+	dec *json.Decoder
+}
+
+// NewStateScanner returns a StateScanner that reads state JSON from r.
+// A StateScanner consumes its reader, so it can only be used for a
+// single lookup.
+func NewStateScanner(r io.Reader) *StateScanner {
+	return &StateScanner{dec: json.NewDecoder(r)}
+}
+
+// Module returns the module with the given path, such as
+// []string{"root", "child"}. If the module isn't in the state, nil is
+// returned with no error.
+func (s *StateScanner) Module(path []string) (*terraform.ModuleState, error) {
+	var result *terraform.ModuleState
+	err := s.scan(path, func(raw json.RawMessage) error {
+		result = new(terraform.ModuleState)
+		return json.Unmarshal(raw, result)
+	}, "", nil)
+
+	return result, err
+}
+
+// Resource returns the resource with the given key, such as
+// "aws_instance.foo.0", in the module with the given path. If the
+// resource isn't in the state, nil is returned with no error.
+func (s *StateScanner) Resource(
+	path []string, key string) (*terraform.ResourceState, error) {
+	var result *terraform.ResourceState
+	err := s.scan(path, nil, key, func(raw json.RawMessage) error {
+		result = new(terraform.ResourceState)
+		return json.Unmarshal(raw, result)
+	})
+
+	return result, err
+}
+
+// State returns a state containing only the modules for whose path match
+// returns true, along with the state's top-level fields such as the
+// serial and lineage. Modules that don't match are skipped without being
+// decoded. The result always has a root module, which is empty if match
+// didn't select it, so it can be used like any other state.
+func (s *StateScanner) State(match func(path []string) bool) (*terraform.State, error) {
+	if err := s.expectDelim('{'); err != nil {
+		return nil, err
+	}
+
+	top := make(map[string]json.RawMessage)
+	var modules []*terraform.ModuleState
+	for s.dec.More() {
+		name, err := s.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if name == "modules" {
+			modules, err = s.matchModules(match)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		var raw json.RawMessage
+		if err := s.dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		top[name] = raw
+	}
+
+	if raw, ok := top["version"]; ok {
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		if v != terraform.StateVersion {
+			return nil, fmt.Errorf(
+				"state version %d can't be scanned, only version %d is supported",
+				v, terraform.StateVersion)
+		}
+	}
+
+	// Decode everything but the modules through the state's own JSON
+	// handling, then add the modules we kept.
+	raw, err := json.Marshal(top)
+	if err != nil {
+		return nil, err
+	}
+	result := new(terraform.State)
+	if err := json.Unmarshal(raw, result); err != nil {
+		return nil, err
+	}
+	for _, m := range modules {
+		result.AddModuleState(m)
+	}
+	result.Init()
+
+	return result, nil
+}
+
+// matchModules decodes the modules array, keeping the modules for whose
+// path match returns true.
+func (s *StateScanner) matchModules(
+	match func(path []string) bool) ([]*terraform.ModuleState, error) {
+	if err := s.expectDelim('['); err != nil {
+		return nil, err
+	}
+
+	var result []*terraform.ModuleState
+	for s.dec.More() {
+		var raw json.RawMessage
+		if err := s.dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		var m struct {
+			Path []string `json:"path"`
+		}
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		if !match(m.Path) {
+			continue
+		}
+
+		mod := new(terraform.ModuleState)
+		if err := json.Unmarshal(raw, mod); err != nil {
+			return nil, err
+		}
+		result = append(result, mod)
+	}
+
+	return result, s.expectDelim(']')
+}
+
+// scan walks the top-level state object looking for the module at path.
+// If moduleFn is set, it is called with the whole matching module.
+// Otherwise resourceFn is called with the resource named key within it.
+func (s *StateScanner) scan(
+	path []string,
+	moduleFn func(json.RawMessage) error,
+	key string,
+	resourceFn func(json.RawMessage) error) error {
+	if err := s.expectDelim('{'); err != nil {
+		return err
+	}
+
+	for s.dec.More() {
+		name, err := s.key()
+		if err != nil {
+			return err
+		}
+
+		switch name {
+		case "version":
+			var v int
+			if err := s.dec.Decode(&v); err != nil {
+				return err
+			}
+			if v != terraform.StateVersion {
+				return fmt.Errorf(
+					"state version %d can't be scanned, only version %d is supported",
+					v, terraform.StateVersion)
+			}
+		case "modules":
+			return s.scanModules(path, moduleFn, key, resourceFn)
+		default:
+			if err := s.skip(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *StateScanner) scanModules(
+	path []string,
+	moduleFn func(json.RawMessage) error,
+	key string,
+	resourceFn func(json.RawMessage) error) error {
+	if err := s.expectDelim('['); err != nil {
+		return err
+	}
+
+	for s.dec.More() {
+		// Modules that the caller wants whole are simply buffered
+		// and compared afterwards.
+		if moduleFn != nil {
+			var raw json.RawMessage
+			if err := s.dec.Decode(&raw); err != nil {
+				return err
+			}
+
+			var m struct {
+				Path []string `json:"path"`
+			}
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return err
+			}
+			if reflect.DeepEqual(m.Path, path) {
+				return moduleFn(raw)
+			}
+
+			continue
+		}
+
+		found, err := s.scanModule(path, key, resourceFn)
+		if err != nil || found {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanModule scans a single module object for the resource key, calling
+// resourceFn and returning true if it is found in a module matching path.
+func (s *StateScanner) scanModule(
+	path []string,
+	key string,
+	resourceFn func(json.RawMessage) error) (bool, error) {
+	if err := s.expectDelim('{'); err != nil {
+		return false, err
+	}
+
+	// Terraform writes "path" before "resources", but if the state was
+	// written by something else we may see the resource before knowing
+	// whether the module matches, so hold onto it until the end.
+	var modPath []string
+	var pathKnown bool
+	var candidate json.RawMessage
+	for s.dec.More() {
+		name, err := s.key()
+		if err != nil {
+			return false, err
+		}
+
+		switch name {
+		case "path":
+			if err := s.dec.Decode(&modPath); err != nil {
+				return false, err
+			}
+			pathKnown = true
+		case "resources":
+			if pathKnown && !reflect.DeepEqual(modPath, path) {
+				if err := s.skip(); err != nil {
+					return false, err
+				}
+
+				continue
+			}
+
+			raw, err := s.scanResources(key)
+			if err != nil {
+				return false, err
+			}
+			if raw != nil {
+				candidate = raw
+			}
+		default:
+			if err := s.skip(); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := s.expectDelim('}'); err != nil {
+		return false, err
+	}
+
+	if candidate == nil || !reflect.DeepEqual(modPath, path) {
+		return false, nil
+	}
+
+	return true, resourceFn(candidate)
+}
+
+// scanResources scans a resources object, returning the raw JSON of the
+// resource named key, or nil if it isn't there.
+func (s *StateScanner) scanResources(key string) (json.RawMessage, error) {
+	if err := s.expectDelim('{'); err != nil {
+		return nil, err
+	}
+
+	var result json.RawMessage
+	for s.dec.More() {
+		name, err := s.key()
+		if err != nil {
+			return nil, err
+		}
+
+		if name != key {
+			if err := s.skip(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if err := s.dec.Decode(&result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, s.expectDelim('}')
+}
+
+// key reads the next object key.
+func (s *StateScanner) key() (string, error) {
+	t, err := s.dec.Token()
+	if err != nil {
+		return "", err
+	}
+
+	k, ok := t.(string)
+	if !ok {
+		return "", fmt.Errorf("expected object key in state, got %v", t)
+	}
+
+	return k, nil
+}
+
+// skip reads past the next value without decoding it.
+func (s *StateScanner) skip() error {
+	var raw json.RawMessage
+	return s.dec.Decode(&raw)
+}
+
+func (s *StateScanner) expectDelim(d json.Delim) error {
+	t, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+
+	if t != d {
+		return fmt.Errorf("expected %q in state, got %v", d, t)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestStateScanner_resource(t *testing.T) {
+	raw := testStateScannerLarge(t)
+
+	s := NewStateScanner(bytes.NewReader(raw))
+	r, err := s.Resource([]string{"root", "child"}, "aws_instance.foo.500")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if r == nil {
+		t.Fatal("resource not found")
+	}
+	if r.Primary.ID != "child-500" {
+		t.Fatalf("bad: %#v", r.Primary)
+	}
+	if r.Primary.Attributes["index"] != "500" {
+		t.Fatalf("bad: %#v", r.Primary.Attributes)
+	}
+}
+
+func TestStateScanner_resourceNotFound(t *testing.T) {
+	raw := testStateScannerLarge(t)
+
+	cases := []struct {
+		Path []string
+		Key  string
+	}{
+		{[]string{"root"}, "aws_instance.foo.1000"},
+		{[]string{"root", "nope"}, "aws_instance.foo.1"},
+	}
+
+	for _, tc := range cases {
+		s := NewStateScanner(bytes.NewReader(raw))
+		r, err := s.Resource(tc.Path, tc.Key)
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Key, err)
+		}
+		if r != nil {
+			t.Fatalf("%s: should not be found: %#v", tc.Key, r)
+		}
+	}
+}
+
+func TestStateScanner_resourceBeforePath(t *testing.T) {
+	raw := `{
+		"version": 3,
+		"modules": [
+			{
+				"resources": {"aws_instance.foo": {"type": "aws_instance", "primary": {"id": "wrong"}}},
+				"path": ["root", "other"]
+			},
+			{
+				"resources": {"aws_instance.foo": {"type": "aws_instance", "primary": {"id": "right"}}},
+				"path": ["root"]
+			}
+		]
+	}`
+
+	s := NewStateScanner(strings.NewReader(raw))
+	r, err := s.Resource([]string{"root"}, "aws_instance.foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if r == nil || r.Primary.ID != "right" {
+		t.Fatalf("bad: %#v", r)
+	}
+}
+
+func TestStateScanner_module(t *testing.T) {
+	raw := testStateScannerLarge(t)
+
+	s := NewStateScanner(bytes.NewReader(raw))
+	m, err := s.Module([]string{"root", "child"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if m == nil {
+		t.Fatal("module not found")
+	}
+	if len(m.Resources) != 1000 {
+		t.Fatalf("bad: %d", len(m.Resources))
+	}
+}
+
+func TestStateScanner_stateWithoutRoot(t *testing.T) {
+	raw := testStateScannerLarge(t)
+
+	s := NewStateScanner(bytes.NewReader(raw))
+	state, err := s.State(func(path []string) bool {
+		return len(path) > 1
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	root := state.RootModule()
+	if len(root.Resources) != 0 {
+		t.Fatalf("bad: %d", len(root.Resources))
+	}
+	if root.Outputs == nil || root.Resources == nil {
+		t.Fatalf("root module not initialized: %#v", root)
+	}
+
+	child := state.ModuleByPath([]string{"root", "child"})
+	if child == nil || len(child.Resources) != 1000 {
+		t.Fatalf("bad: %#v", child)
+	}
+}
+
+func TestStateScanner_version(t *testing.T) {
+	s := NewStateScanner(strings.NewReader(`{"version": 1, "modules": []}`))
+	if _, err := s.Resource([]string{"root"}, "aws_instance.foo"); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+// testStateScannerLarge returns the JSON of a state with 1000 resources
+// in each of the root and "child" modules.
+func testStateScannerLarge(t *testing.T) []byte {
+	state := terraform.NewState()
+	child := state.AddModule([]string{"root", "child"})
+	for i := 0; i < 1000; i++ {
+		for _, m := range []*terraform.ModuleState{state.RootModule(), child} {
+			name := "root"
+			if m == child {
+				name = "child"
+			}
+
+			m.Resources[fmt.Sprintf("aws_instance.foo.%d", i)] = &terraform.ResourceState{
+				Type: "aws_instance",
+				Primary: &terraform.InstanceState{
+					ID: fmt.Sprintf("%s-%d", name, i),
+					Attributes: map[string]string{
+						"index": fmt.Sprintf("%d", i),
+					},
+				},
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(state, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return buf.Bytes()
+}
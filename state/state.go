@@ -40,3 +40,25 @@ type StateRefresher interface {
 type StatePersister interface {
 	PersistState() error
 }
+
+// Locker is implemented by states that can be locked to keep concurrent
+// runs of Terraform from modifying the same state. Lock returns an ID
+// for the lock that must be given to Unlock to release it.
+type Locker interface {
+	Lock(info *LockInfo) (string, error)
+	Unlock(id string) error
+}
+
+// ForceUnlocker is implemented by Lockers whose lock can be inspected
+// and removed by a process other than the one that took it, so that a
+// lock left behind by a crashed run can be cleared.
+type ForceUnlocker interface {
+	Locker
+
+	// LockInfo returns the info of the lock currently held on the
+	// state by anyone, or nil if the state isn't locked.
+	LockInfo() (*LockInfo, error)
+
+	// ForceUnlock removes the lock with the given ID, whoever holds it.
+	ForceUnlock(id string) error
+}
@@ -119,7 +119,7 @@ func TestState(t *testing.T, s interface{}) {
 		// Check that State() returns a copy by modifying the copy and comparing
 		// to the current state.
 		stateCopy := reader.State()
-		stateCopy.Serial++
+		stateCopy.IncrementSerial()
 		if reflect.DeepEqual(stateCopy, current) {
 			t.Fatal("State() should return a copy")
 		}
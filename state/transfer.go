@@ -0,0 +1,94 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// Pull refreshes s and returns its state serialized as JSON, which is the
+// same wherever the state is stored. If there is no state, nil is returned.
+func Pull(s State) ([]byte, error) {
+	if err := s.RefreshState(); err != nil {
+		return nil, err
+	}
+
+	state := s.State()
+	if state == nil {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if err := terraform.WriteState(state, &buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Push replaces the state in s with the serialized state raw and persists
+// it. Unless force is set, Push fails with a PushConflictError rather than
+// replace a state with a different lineage or a newer serial.
+func Push(s State, raw []byte, force bool) error {
+	pushed, err := terraform.ReadState(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("Error reading the state to push: %s", err)
+	}
+
+	if err := s.RefreshState(); err != nil {
+		return err
+	}
+
+	if current := s.State(); current != nil && !force {
+		if !current.SameLineage(pushed) || current.Serial > pushed.Serial {
+			reason := "serial is newer"
+			if !current.SameLineage(pushed) {
+				reason = "lineage differs"
+			}
+
+			return &PushConflictError{
+				RemoteConflictError: RemoteConflictError{
+					Reason: reason,
+				},
+				Lineage:     current.Lineage,
+				Serial:      current.Serial,
+				PushLineage: pushed.Lineage,
+				PushSerial:  pushed.Serial,
+			}
+		}
+	}
+
+	if err := s.WriteState(pushed); err != nil {
+		return err
+	}
+
+	return s.PersistState()
+}
+
+// PushConflictError is returned by Push when the pushed state would
+// replace a state that it doesn't descend from.
+type PushConflictError struct {
+	// RemoteConflictError says whether the lineage or serial conflicts.
+	RemoteConflictError
+
+	// Lineage and Serial are those of the state being replaced.
+	Lineage string
+	Serial  int64
+
+	// PushLineage and PushSerial are those of the state being pushed.
+	PushLineage string
+	PushSerial  int64
+}
+
+func (e *PushConflictError) Error() string {
+	if e.Lineage != "" && e.PushLineage != "" && e.Lineage != e.PushLineage {
+		return fmt.Sprintf(
+			"cannot replace a state with lineage %q with one with lineage %q",
+			e.Lineage, e.PushLineage)
+	}
+
+	return fmt.Sprintf(
+		"cannot replace a state with serial %d with an older one with serial %d",
+		e.Serial, e.PushSerial)
+}
@@ -0,0 +1,97 @@
+package state
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestPull(t *testing.T) {
+	s := &InmemState{}
+	raw, err := Pull(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if raw != nil {
+		t.Fatalf("expected no state, got: %s", raw)
+	}
+
+	if err := s.WriteState(TestStateInitial()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	raw, err = Pull(s)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := terraform.ReadState(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !actual.Equal(s.State()) {
+		t.Fatalf("bad: %s", raw)
+	}
+}
+
+func TestPush(t *testing.T) {
+	initial := TestStateInitial()
+	initial.Lineage = "5d1ad1a1-4027-4665-a908-dbe6adff11d8"
+	initial.Serial = 2
+
+	cases := map[string]struct {
+		Lineage string
+		Serial  int64
+		Force   bool
+		Err     bool
+	}{
+		"newer":          {initial.Lineage, 3, false, false},
+		"same serial":    {initial.Lineage, 2, false, false},
+		"older":          {initial.Lineage, 1, false, true},
+		"older forced":   {initial.Lineage, 1, true, false},
+		"lineage":        {"e0c8d4b2-9e3a-4d2c-9a5c-3c7c1c7c9a11", 3, false, true},
+		"lineage forced": {"e0c8d4b2-9e3a-4d2c-9a5c-3c7c1c7c9a11", 3, true, false},
+	}
+
+	for name, tc := range cases {
+		s := &InmemState{}
+		if err := s.WriteState(initial.DeepCopy()); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		pushed := initial.DeepCopy()
+		pushed.Lineage = tc.Lineage
+		pushed.Serial = tc.Serial
+		pushed.Modules = nil
+		var buf bytes.Buffer
+		if err := terraform.WriteState(pushed, &buf); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		err := Push(s, buf.Bytes(), tc.Force)
+		if (err != nil) != tc.Err {
+			t.Fatalf("%s: err: %v", name, err)
+		}
+		if err != nil {
+			if _, ok := err.(*PushConflictError); !ok {
+				t.Fatalf("%s: expected PushConflictError, got %T", name, err)
+			}
+			if s.State().Serial != initial.Serial {
+				t.Fatalf("%s: state should not have been replaced", name)
+			}
+
+			continue
+		}
+
+		if s.State().HasResources() {
+			t.Fatalf("%s: state should have been replaced", name)
+		}
+	}
+}
+
+func TestPush_invalid(t *testing.T) {
+	s := &InmemState{}
+	if err := Push(s, []byte("not a state"), false); err == nil {
+		t.Fatal("expected error")
+	}
+}
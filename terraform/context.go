@@ -32,6 +32,10 @@ const (
 	// InputModeStd is the standard operating mode and asks for both variables
 	// and providers.
 	InputModeStd = InputModeVar | InputModeProvider
+
+	// InputModeNone asks for nothing. Unset required variables are then
+	// reported as errors by Validate rather than being prompted for.
+	InputModeNone InputMode = 0
 )
 
 var (
@@ -662,6 +666,50 @@ func (c *Context) Module() *module.Tree {
 	return c.module
 }
 
+// Providers returns the sorted names of the providers that were given to
+// this context and are used by its module tree, either by a provider
+// configuration block or by a resource.
+func (c *Context) Providers() []string {
+	used := make(map[string]struct{})
+	if c.module != nil {
+		moduleProviders(c.module, used)
+	}
+
+	var result []string
+	for _, name := range c.components.ResourceProviders() {
+		if _, ok := used[name]; ok {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+	return result
+}
+
+// moduleProviders adds the names of the providers used by the module
+// tree t to result.
+func moduleProviders(t *module.Tree, result map[string]struct{}) {
+	if cfg := t.Config(); cfg != nil {
+		for _, p := range cfg.ProviderConfigs {
+			result[p.Name] = struct{}{}
+		}
+
+		for _, r := range cfg.Resources {
+			// An aliased provider is referenced as "name.alias"
+			name := resourceProvider(r.Type, r.Provider)
+			if idx := strings.IndexRune(name, '.'); idx != -1 {
+				name = name[:idx]
+			}
+
+			result[name] = struct{}{}
+		}
+	}
+
+	for _, child := range t.Children() {
+		moduleProviders(child, result)
+	}
+}
+
 // Variables will return the mapping of variables that were defined
 // for this Context. If Input was called, this mapping may be different
 // than what was given.
@@ -29,7 +29,7 @@ type basicComponentFactory struct {
 }
 
 func (c *basicComponentFactory) ResourceProviders() []string {
-	result := make([]string, len(c.providers))
+	result := make([]string, 0, len(c.providers))
 	for k, _ := range c.providers {
 		result = append(result, k)
 	}
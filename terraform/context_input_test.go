@@ -490,6 +490,34 @@ func TestContext2Input_varOnlyUnset(t *testing.T) {
 	}
 }
 
+func TestContext2Input_none(t *testing.T) {
+	input := new(MockUIInput)
+	m := testModule(t, "input-vars-unset")
+	p := testProvider("aws")
+	ctx := testContext2(t, &ContextOpts{
+		Module: m,
+		Providers: map[string]ResourceProviderFactory{
+			"aws": testProviderFuncFixed(p),
+		},
+		Variables: map[string]interface{}{
+			"foo": "foovalue",
+		},
+		UIInput: input,
+	})
+
+	if err := ctx.Input(InputModeNone); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if input.InputCalled {
+		t.Fatal("input should not be called")
+	}
+
+	if _, es := ctx.Validate(); len(es) == 0 {
+		t.Fatal("missing required variable should be an error")
+	}
+}
+
 func TestContext2Input_varWithDefault(t *testing.T) {
 	input := new(MockUIInput)
 	m := testModule(t, "input-var-default")
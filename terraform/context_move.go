@@ -0,0 +1,83 @@
+package terraform
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/hashicorp/terraform/config"
+)
+
+// MoveResource moves the resource at src in the context's state to dst,
+// returning the updated state. This is the programmatic equivalent of
+// "terraform state mv" for a single resource and doesn't require a
+// plan or apply.
+//
+// If src and dst are in the same module, dependencies on src recorded
+// by other resources in that module are rewritten to dst. The state
+// serial is incremented on success.
+func (c *Context) MoveResource(src, dst *ResourceAddress) (*State, error) {
+	// Hold a lock since we modify our own state here
+	v := c.acquireRun("move")
+	defer c.releaseRun(v)
+
+	if src.Type == "" || dst.Type == "" {
+		return nil, fmt.Errorf(
+			"source and destination must be resource addresses: %s, %s", src, dst)
+	}
+
+	// Work on a copy so that a failed move leaves our state untouched
+	state := c.state.DeepCopy()
+
+	filter := &StateFilter{State: state}
+	results, err := filter.Filter(src.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var rs *ResourceState
+	for _, r := range results {
+		v, ok := r.Value.(*ResourceState)
+		if !ok {
+			continue
+		}
+
+		if rs != nil {
+			return nil, fmt.Errorf(
+				"%s matches more than one resource; move each instance by index", src)
+		}
+		rs = v
+	}
+	if rs == nil {
+		return nil, fmt.Errorf("resource not found in state: %s", src)
+	}
+
+	if err := state.Remove(src.String()); err != nil {
+		return nil, err
+	}
+	if err := state.Add(src.String(), dst.String(), rs); err != nil {
+		return nil, err
+	}
+
+	if reflect.DeepEqual(src.Path, dst.Path) {
+		path := append([]string{"root"}, src.Path...)
+		if mod := state.ModuleByPath(path); mod != nil {
+			mod.renameDependencies(
+				resourceDependencyName(src), resourceDependencyName(dst))
+		}
+	}
+
+	state.IncrementSerial()
+	c.state = state
+	return c.state, nil
+}
+
+// resourceDependencyName returns the name that other resources use to
+// record a dependency on addr, such as "aws_instance.foo".
+func resourceDependencyName(addr *ResourceAddress) string {
+	name := fmt.Sprintf("%s.%s", addr.Type, addr.Name)
+	if addr.Mode == config.DataResourceMode {
+		name = "data." + name
+	}
+
+	return name
+}
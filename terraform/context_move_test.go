@@ -0,0 +1,84 @@
+package terraform
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContextMoveResource(t *testing.T) {
+	ctx := testContext2(t, &ContextOpts{
+		State: &State{
+			Modules: []*ModuleState{
+				&ModuleState{
+					Path: rootModulePath,
+					Resources: map[string]*ResourceState{
+						"aws_instance.foo": &ResourceState{
+							Type: "aws_instance",
+							Primary: &InstanceState{
+								ID: "foo",
+							},
+						},
+						"aws_instance.bar": &ResourceState{
+							Type:         "aws_instance",
+							Dependencies: []string{"aws_instance.foo"},
+							Primary: &InstanceState{
+								ID: "bar",
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	src, err := ParseResourceAddress("aws_instance.foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dst, err := ParseResourceAddress("aws_instance.baz")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	state, err := ctx.MoveResource(src, dst)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := strings.TrimSpace(state.String())
+	expected := strings.TrimSpace(testContextMoveResourceStr)
+	if actual != expected {
+		t.Fatalf("bad: \n%s", actual)
+	}
+
+	if state.Serial != 1 {
+		t.Fatalf("bad serial: %d", state.Serial)
+	}
+}
+
+func TestContextMoveResource_notFound(t *testing.T) {
+	ctx := testContext2(t, &ContextOpts{})
+
+	src, err := ParseResourceAddress("aws_instance.foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	dst, err := ParseResourceAddress("aws_instance.baz")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := ctx.MoveResource(src, dst); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+const testContextMoveResourceStr = `
+aws_instance.bar:
+  ID = bar
+
+  Dependencies:
+    aws_instance.baz
+aws_instance.baz:
+  ID = foo
+`
@@ -2405,6 +2405,47 @@ STATE:
 	}
 }
 
+// Test that targeting a module targets everything in that module's tree,
+// including nested modules, but nothing in sibling modules.
+func TestContext2Plan_targetedModuleTree(t *testing.T) {
+	m := testModule(t, "plan-targeted-module-tree")
+	p := testProvider("aws")
+	p.DiffFn = testDiffFn
+	ctx := testContext2(t, &ContextOpts{
+		Module: m,
+		Providers: map[string]ResourceProviderFactory{
+			"aws": testProviderFuncFixed(p),
+		},
+		Targets: []string{"module.vpc"},
+	})
+
+	plan, err := ctx.Plan()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := strings.TrimSpace(plan.String())
+	expected := strings.TrimSpace(`
+DIFF:
+
+module.vpc:
+  CREATE: aws_vpc.main
+    foo:  "" => "bar"
+    type: "" => "aws_vpc"
+module.vpc.subnets:
+  CREATE: aws_subnet.main
+    foo:  "" => "bar"
+    type: "" => "aws_subnet"
+
+STATE:
+
+<no state>
+	`)
+	if actual != expected {
+		t.Fatalf("expected:\n%s\n\ngot:\n%s", expected, actual)
+	}
+}
+
 func TestContext2Plan_targetedOrphan(t *testing.T) {
 	m := testModule(t, "plan-targeted-orphan")
 	p := testProvider("aws")
@@ -2,6 +2,7 @@ package terraform
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -145,6 +146,24 @@ func TestNewContextState(t *testing.T) {
 	}
 }
 
+func TestContextProviders(t *testing.T) {
+	m := testModule(t, "context-providers")
+	ctx := testContext2(t, &ContextOpts{
+		Module: m,
+		Providers: map[string]ResourceProviderFactory{
+			"aws":       testProviderFuncFixed(testProvider("aws")),
+			"null":      testProviderFuncFixed(testProvider("null")),
+			"openstack": testProviderFuncFixed(testProvider("openstack")),
+		},
+	})
+
+	actual := ctx.Providers()
+	expected := []string{"aws", "null"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
 func testContext2(t *testing.T, opts *ContextOpts) *Context {
 	// Enable the shadow graph
 	opts.Shadow = true
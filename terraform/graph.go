@@ -6,6 +6,7 @@ import (
 	"runtime/debug"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform/dag"
 )
@@ -29,6 +30,11 @@ type Graph struct {
 	// RootModuleName
 	Path []string
 
+	// EstimatedDuration, if set, estimates how long the vertex with the
+	// given name takes to execute. It is used by CriticalPath; vertices
+	// are assumed to take no time if it isn't set.
+	EstimatedDuration func(name string) time.Duration
+
 	// annotations are the annotations that are added to vertices. Annotations
 	// are arbitrary metadata taht is used for various logic. Annotations
 	// should have unique keys that are referenced via constants.
@@ -209,6 +215,82 @@ func (g *Graph) Walk(walker GraphWalker) error {
 	return g.walk(walker)
 }
 
+// CriticalPath returns the names of the vertices on the chain of
+// dependencies with the longest total estimated duration, in the order
+// they would be executed, along with that total. This is the minimum
+// time a walk of the graph could take with unlimited parallelism.
+//
+// Ties are broken by the number of vertices on the chain and then by
+// name, so the result is deterministic. Subgraphs are not descended into.
+func (g *Graph) CriticalPath() ([]string, time.Duration) {
+	// chain is the longest chain of dependencies that ends by executing
+	// a vertex, linked through the dependency executed before it.
+	type chain struct {
+		name     string
+		duration time.Duration
+		length   int
+		prev     *chain
+	}
+
+	longer := func(a, b *chain) bool {
+		if b == nil {
+			return true
+		}
+		if a.duration != b.duration {
+			return a.duration > b.duration
+		}
+		if a.length != b.length {
+			return a.length > b.length
+		}
+
+		return a.name < b.name
+	}
+
+	chains := make(map[dag.Vertex]*chain)
+	var visit func(v dag.Vertex) *chain
+	visit = func(v dag.Vertex) *chain {
+		if c, ok := chains[v]; ok {
+			return c
+		}
+
+		var prev *chain
+		for _, dep := range g.DownEdges(v).List() {
+			if c := visit(dep); longer(c, prev) {
+				prev = c
+			}
+		}
+
+		c := &chain{name: dag.VertexName(v), length: 1, prev: prev}
+		if g.EstimatedDuration != nil {
+			c.duration = g.EstimatedDuration(c.name)
+		}
+		if prev != nil {
+			c.duration += prev.duration
+			c.length += prev.length
+		}
+
+		chains[v] = c
+		return c
+	}
+
+	var longest *chain
+	for _, v := range g.Vertices() {
+		if c := visit(v); longer(c, longest) {
+			longest = c
+		}
+	}
+	if longest == nil {
+		return nil, 0
+	}
+
+	result := make([]string, longest.length)
+	for c := longest; c != nil; c = c.prev {
+		result[c.length-1] = c.name
+	}
+
+	return result, longest.duration
+}
+
 func (g *Graph) init() {
 	if g.annotations == nil {
 		g.annotations = make(map[dag.Vertex]map[string]interface{})
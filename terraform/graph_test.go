@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform/dag"
 )
@@ -71,6 +72,46 @@ func TestGraphReplace_DependableWithNonDependable(t *testing.T) {
 	}
 }
 
+func TestGraphCriticalPath(t *testing.T) {
+	durations := map[string]time.Duration{
+		"a": 1 * time.Second,
+		"b": 5 * time.Second,
+		"c": 2 * time.Second,
+		"d": 1 * time.Second,
+	}
+
+	// A diamond: d depends on b and c, which both depend on a
+	var g Graph
+	g.EstimatedDuration = func(name string) time.Duration {
+		return durations[name]
+	}
+	g.Add("a")
+	g.Add("b")
+	g.Add("c")
+	g.Add("d")
+	g.Connect(dag.BasicEdge("b", "a"))
+	g.Connect(dag.BasicEdge("c", "a"))
+	g.Connect(dag.BasicEdge("d", "b"))
+	g.Connect(dag.BasicEdge("d", "c"))
+
+	path, duration := g.CriticalPath()
+	expected := []string{"a", "b", "d"}
+	if !reflect.DeepEqual(path, expected) {
+		t.Fatalf("bad path: %#v", path)
+	}
+	if duration != 7*time.Second {
+		t.Fatalf("bad duration: %s", duration)
+	}
+}
+
+func TestGraphCriticalPath_empty(t *testing.T) {
+	var g Graph
+	path, duration := g.CriticalPath()
+	if path != nil || duration != 0 {
+		t.Fatalf("bad: %#v, %s", path, duration)
+	}
+}
+
 func TestGraphWalk_panicWrap(t *testing.T) {
 	var g Graph
 
@@ -6,8 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/config/module"
 )
 
@@ -48,6 +52,191 @@ func (p *Plan) Context(opts *ContextOpts) (*Context, error) {
 	return NewContext(opts)
 }
 
+// ResourceAddresses returns the addresses of all resource instances that
+// have changes in the plan's diff, sorted by their string form.
+func (p *Plan) ResourceAddresses() []*ResourceAddress {
+	return p.resourceAddresses(func(d *InstanceDiff) bool {
+		return !d.Empty()
+	})
+}
+
+// ResourceAddressesForAction is like ResourceAddresses, but only returns
+// the addresses of resource instances whose diff is of the given type.
+func (p *Plan) ResourceAddressesForAction(t DiffChangeType) []*ResourceAddress {
+	return p.resourceAddresses(func(d *InstanceDiff) bool {
+		return d.ChangeType() == t
+	})
+}
+
+func (p *Plan) resourceAddresses(f func(*InstanceDiff) bool) []*ResourceAddress {
+	if p.Diff == nil {
+		return nil
+	}
+
+	var result []*ResourceAddress
+	for _, m := range p.Diff.Modules {
+		for k, d := range m.Resources {
+			if d == nil || !f(d) {
+				continue
+			}
+
+			addr, err := parseResourceAddressInternal(k)
+			if err != nil {
+				// Diff keys are always written by Terraform, so this
+				// should only happen with a hand-crafted plan.
+				log.Printf("[WARN] Plan: ignoring bad resource key %q: %s", k, err)
+				continue
+			}
+
+			if len(m.Path) > 1 {
+				addr.Path = m.Path[1:]
+			}
+
+			result = append(result, addr)
+		}
+	}
+
+	sort.Sort(resourceAddressSort(result))
+	return result
+}
+
+// UnmatchedTargets returns the plan's targets that don't address any
+// resource in either its diff or its state, in the order they were given.
+// A target like this is almost always a typo, since otherwise Terraform
+// quietly plans no changes for it.
+func (p *Plan) UnmatchedTargets() ([]string, error) {
+	if len(p.Targets) == 0 {
+		return nil, nil
+	}
+
+	addrs := p.resourceAddresses(func(*InstanceDiff) bool { return true })
+	if p.State != nil {
+		for _, m := range p.State.Modules {
+			for k := range m.Resources {
+				addr, err := parseResourceAddressInternal(k)
+				if err != nil {
+					continue
+				}
+				if len(m.Path) > 1 {
+					addr.Path = m.Path[1:]
+				}
+
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	var result []string
+	for _, t := range p.Targets {
+		target, err := ParseResourceAddress(t)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid target %q: %s", t, err)
+		}
+
+		matched := false
+		for _, addr := range addrs {
+			if targetMatches(target, addr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			result = append(result, t)
+		}
+	}
+
+	return result, nil
+}
+
+// targetMatches returns true if target addresses addr. A target with no
+// resource type addresses everything within its module, including nested
+// modules.
+func targetMatches(target, addr *ResourceAddress) bool {
+	if target.Type != "" {
+		return target.Equals(addr)
+	}
+
+	// modulePathHasPrefix doesn't treat the root as a prefix, but a target
+	// without a type or a module path addresses the whole configuration.
+	return len(target.Path) == 0 || modulePathHasPrefix(addr.Path, target.Path)
+}
+
+// resourceAddressSort implements sort.Interface to sort addresses by
+// their string form.
+type resourceAddressSort []*ResourceAddress
+
+func (l resourceAddressSort) Len() int           { return len(l) }
+func (l resourceAddressSort) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l resourceAddressSort) Less(i, j int) bool { return l[i].String() < l[j].String() }
+
+// ProjectedState returns the state that applying this plan is expected
+// to produce, computed only from the plan's state and diff. No providers
+// are called and the plan itself is not modified.
+//
+// Attributes that won't be known until apply, including the IDs of new
+// resources, are set to config.UnknownVariableValue in the result.
+func (p *Plan) ProjectedState() (*State, error) {
+	result := p.State.DeepCopy()
+	if result == nil {
+		result = NewState()
+	}
+
+	if p.Diff == nil {
+		return result, nil
+	}
+
+	for _, md := range p.Diff.Modules {
+		mod := result.ModuleByPath(md.Path)
+		if mod == nil {
+			mod = result.AddModule(md.Path)
+		}
+
+		for k, d := range md.Resources {
+			if d == nil || d.Empty() {
+				continue
+			}
+
+			switch d.ChangeType() {
+			case DiffDestroy:
+				delete(mod.Resources, k)
+				continue
+			case DiffNone:
+				continue
+			}
+
+			rs, ok := mod.Resources[k]
+			if !ok {
+				key, err := ParseResourceStateKey(k)
+				if err != nil {
+					return nil, fmt.Errorf(
+						"module %s: %s", strings.Join(md.Path, "."), err)
+				}
+
+				rs = &ResourceState{Type: key.Type}
+				mod.Resources[k] = rs
+			}
+
+			// A replaced resource starts over rather than building on
+			// the attributes of the instance being destroyed.
+			var base *InstanceState
+			if d.ChangeType() == DiffUpdate {
+				base = rs.Primary
+			}
+
+			is := base.MergeDiff(d)
+			if id, ok := is.Attributes["id"]; ok {
+				is.ID = id
+			} else if base == nil {
+				is.ID = config.UnknownVariableValue
+			}
+
+			rs.Primary = is
+		}
+	}
+
+	return result, nil
+}
+
 func (p *Plan) String() string {
 	buf := new(bytes.Buffer)
 	buf.WriteString("DIFF:\n\n")
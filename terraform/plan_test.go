@@ -2,6 +2,7 @@ package terraform
 
 import (
 	"bytes"
+	"reflect"
 	"strings"
 
 	"testing"
@@ -71,3 +72,267 @@ func TestReadWritePlan(t *testing.T) {
 		t.Fatalf("bad:\n\n%s\n\nexpected:\n\n%s", actualStr, expectedStr)
 	}
 }
+
+func TestPlanResourceAddresses(t *testing.T) {
+	plan := &Plan{
+		Diff: &Diff{
+			Modules: []*ModuleDiff{
+				&ModuleDiff{
+					Path: rootModulePath,
+					Resources: map[string]*InstanceDiff{
+						"aws_instance.foo.1": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"id": &ResourceAttrDiff{
+									NewComputed: true,
+									RequiresNew: true,
+								},
+							},
+						},
+						"aws_instance.foo.0": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"id": &ResourceAttrDiff{
+									NewComputed: true,
+									RequiresNew: true,
+								},
+							},
+						},
+						"aws_instance.bar": &InstanceDiff{
+							Destroy: true,
+						},
+						"aws_instance.unchanged": &InstanceDiff{},
+					},
+				},
+				&ModuleDiff{
+					Path: []string{"root", "child"},
+					Resources: map[string]*InstanceDiff{
+						"aws_instance.baz": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"foo": &ResourceAttrDiff{
+									Old: "foo",
+									New: "bar",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Addrs    []*ResourceAddress
+		Expected []string
+	}{
+		{
+			plan.ResourceAddresses(),
+			[]string{
+				"aws_instance.bar",
+				"aws_instance.foo[0]",
+				"aws_instance.foo[1]",
+				"module.child.aws_instance.baz",
+			},
+		},
+		{
+			plan.ResourceAddressesForAction(DiffCreate),
+			[]string{"aws_instance.foo[0]", "aws_instance.foo[1]"},
+		},
+		{
+			plan.ResourceAddressesForAction(DiffDestroy),
+			[]string{"aws_instance.bar"},
+		},
+		{
+			plan.ResourceAddressesForAction(DiffUpdate),
+			[]string{"module.child.aws_instance.baz"},
+		},
+		{
+			plan.ResourceAddressesForAction(DiffDestroyCreate),
+			nil,
+		},
+	}
+
+	for i, tc := range cases {
+		var actual []string
+		for _, addr := range tc.Addrs {
+			actual = append(actual, addr.String())
+		}
+
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Fatalf("%d: bad: %#v", i, actual)
+		}
+	}
+}
+
+func TestPlanUnmatchedTargets(t *testing.T) {
+	plan := &Plan{
+		Diff: &Diff{
+			Modules: []*ModuleDiff{
+				&ModuleDiff{
+					Path: []string{"root", "child"},
+					Resources: map[string]*InstanceDiff{
+						"aws_instance.baz": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"id": &ResourceAttrDiff{
+									NewComputed: true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		State: &State{
+			Modules: []*ModuleState{
+				&ModuleState{
+					Path: rootModulePath,
+					Resources: map[string]*ResourceState{
+						"aws_instance.foo.0": &ResourceState{
+							Type:    "aws_instance",
+							Primary: &InstanceState{ID: "a"},
+						},
+						"data.aws_ami.ubuntu": &ResourceState{
+							Type:    "aws_ami",
+							Primary: &InstanceState{ID: "b"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Targets  []string
+		Expected []string
+	}{
+		{nil, nil},
+		{[]string{"aws_instance.foo"}, nil},
+		{[]string{"aws_instance.foo[0]"}, nil},
+		{[]string{"data.aws_ami.ubuntu"}, nil},
+		{[]string{"module.child"}, nil},
+		{[]string{"module.child.aws_instance.baz"}, nil},
+		{[]string{"aws_instance.foo[1]"}, []string{"aws_instance.foo[1]"}},
+		{[]string{"aws_ami.ubuntu"}, []string{"aws_ami.ubuntu"}},
+		{[]string{"aws_instance.baz"}, []string{"aws_instance.baz"}},
+		{
+			[]string{"module.other", "aws_instance.foo", "aws_instance.bar"},
+			[]string{"module.other", "aws_instance.bar"},
+		},
+	}
+
+	for i, tc := range cases {
+		plan.Targets = tc.Targets
+		actual, err := plan.UnmatchedTargets()
+		if err != nil {
+			t.Fatalf("%d: err: %s", i, err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Fatalf("%d: bad: %#v", i, actual)
+		}
+	}
+
+	plan.Targets = []string{"aws_instance"}
+	if _, err := plan.UnmatchedTargets(); err == nil {
+		t.Fatal("should error on an invalid target")
+	}
+}
+
+func TestPlanProjectedState(t *testing.T) {
+	plan := &Plan{
+		State: &State{
+			Modules: []*ModuleState{
+				&ModuleState{
+					Path: rootModulePath,
+					Resources: map[string]*ResourceState{
+						"aws_instance.update": &ResourceState{
+							Type: "aws_instance",
+							Primary: &InstanceState{
+								ID: "i-update",
+								Attributes: map[string]string{
+									"id":  "i-update",
+									"foo": "old",
+									"bar": "kept",
+								},
+							},
+						},
+						"aws_instance.destroy": &ResourceState{
+							Type: "aws_instance",
+							Primary: &InstanceState{
+								ID: "i-destroy",
+							},
+						},
+					},
+				},
+			},
+		},
+		Diff: &Diff{
+			Modules: []*ModuleDiff{
+				&ModuleDiff{
+					Path: rootModulePath,
+					Resources: map[string]*InstanceDiff{
+						"aws_instance.update": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"foo": &ResourceAttrDiff{
+									Old: "old",
+									New: "new",
+								},
+								"baz": &ResourceAttrDiff{
+									NewComputed: true,
+								},
+							},
+						},
+						"aws_instance.destroy": &InstanceDiff{
+							Destroy: true,
+						},
+					},
+				},
+				&ModuleDiff{
+					Path: []string{"root", "child"},
+					Resources: map[string]*InstanceDiff{
+						"aws_instance.create": &InstanceDiff{
+							Attributes: map[string]*ResourceAttrDiff{
+								"id": &ResourceAttrDiff{
+									NewComputed: true,
+									RequiresNew: true,
+								},
+								"foo": &ResourceAttrDiff{
+									New:         "bar",
+									RequiresNew: true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	actual, err := plan.ProjectedState()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := strings.TrimSpace(`
+aws_instance.update:
+  ID = i-update
+  bar = kept
+  baz = 74D93920-ED26-11E3-AC10-0800200C9A66
+  foo = new
+
+module.child:
+  aws_instance.create:
+    ID = 74D93920-ED26-11E3-AC10-0800200C9A66
+    foo = bar
+`)
+	if actual.String() != expected {
+		t.Fatalf("bad:\n\n%s\n\nexpected:\n\n%s", actual, expected)
+	}
+
+	// The plan's own state must be left alone.
+	rs := plan.State.RootModule().Resources["aws_instance.update"]
+	if v := rs.Primary.Attributes["foo"]; v != "old" {
+		t.Fatalf("plan state was modified: foo = %q", v)
+	}
+	if _, ok := plan.State.RootModule().Resources["aws_instance.destroy"]; !ok {
+		t.Fatal("plan state was modified: destroyed resource removed")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/go-version"
@@ -66,6 +68,10 @@ type State struct {
 	// updates.
 	Serial int64 `json:"serial"`
 
+	// LastUpdated is when Serial was last incremented. It is left out of
+	// the state file while it is zero.
+	LastUpdated time.Time `json:"last_updated,omitempty"`
+
 	// Lineage is set when a new, blank state is created and then
 	// never updated. This allows us to determine whether the serials
 	// of two states can be meaningfully compared.
@@ -125,6 +131,67 @@ func (s *State) children(path []string) []*ModuleState {
 	return result
 }
 
+// ExportResources returns the resources in every module of the state for
+// which filter returns true, ordered by module and then by state key.
+// The returned resources are the state's own and must not be modified.
+func (s *State) ExportResources(filter func(*ResourceState) bool) []*ResourceState {
+	s.Lock()
+	defer s.Unlock()
+
+	var result []*ResourceState
+	s.exportResources(filter, func(_ []string, _ string, r *ResourceState) {
+		result = append(result, r)
+	})
+
+	return result
+}
+
+// ExportResourceAddresses is like ExportResources, but returns the
+// addresses of the matching resources instead.
+func (s *State) ExportResourceAddresses(filter func(*ResourceState) bool) []*ResourceAddress {
+	s.Lock()
+	defer s.Unlock()
+
+	var result []*ResourceAddress
+	s.exportResources(filter, func(path []string, k string, _ *ResourceState) {
+		addr, err := parseResourceAddressInternal(k)
+		if err != nil {
+			log.Printf("[WARN] State: ignoring bad resource key %q: %s", k, err)
+			return
+		}
+
+		if len(path) > 1 {
+			addr.Path = path[1:]
+		}
+
+		result = append(result, addr)
+	})
+
+	return result
+}
+
+func (s *State) exportResources(
+	filter func(*ResourceState) bool,
+	fn func(path []string, k string, r *ResourceState)) {
+	for _, mod := range s.Modules {
+		if mod == nil {
+			continue
+		}
+
+		keys := make([]string, 0, len(mod.Resources))
+		for k := range mod.Resources {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if r := mod.Resources[k]; r != nil && filter(r) {
+				fn(mod.Path, k, r)
+			}
+		}
+	}
+}
+
 // AddModule adds the module with the given path to the state.
 //
 // This should be the preferred method to add module states since it
@@ -423,6 +490,56 @@ func (s *State) Remove(addr ...string) error {
 	return nil
 }
 
+// ErrResourceNotFound is returned by RemoveResource when the address
+// doesn't match a resource in the state.
+var ErrResourceNotFound = errors.New("resource not found in state")
+
+// RemoveResource removes the single resource at addr from the state,
+// along with any of its deposed instances. addr must name the resource
+// the way it is keyed in the state, so a resource without a count has
+// no index.
+//
+// Dependencies on the removed instance held by other resources in the
+// same module are dropped, as are dependencies on the resource as a
+// whole once none of its instances remain. The serial is incremented on
+// success.
+func (s *State) RemoveResource(addr *ResourceAddress) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if addr.Type == "" {
+		return fmt.Errorf("not a resource address: %s", addr)
+	}
+
+	mod := s.moduleByPath(append([]string{"root"}, addr.Path...))
+	if mod == nil {
+		return ErrResourceNotFound
+	}
+
+	key := addr.stateId()
+	if _, ok := mod.Resources[key]; !ok {
+		return ErrResourceNotFound
+	}
+	delete(mod.Resources, key)
+	mod.removeDependencies(key, false)
+
+	name := resourceDependencyName(addr)
+	remaining := false
+	for k := range mod.Resources {
+		if k == name || strings.HasPrefix(k, name+".") {
+			remaining = true
+			break
+		}
+	}
+	if !remaining {
+		mod.removeDependencies(name, true)
+	}
+
+	s.prune()
+	s.incrementSerialLocked()
+	return nil
+}
+
 func (s *State) removeModule(path []string, v *ModuleState) {
 	for i, m := range s.Modules {
 		if m == v {
@@ -476,6 +593,146 @@ func (s *State) removeInstance(path []string, r *ResourceState, v *InstanceState
 	}
 }
 
+// RenameModule moves the module at path src, along with all of its
+// descendent modules, to the path dst. Paths are full module paths
+// beginning with "root", as used by ModuleByPath.
+//
+// If src and dst have the same parent, dependencies on the module held
+// by resources and modules within that parent are rewritten to the new
+// name. The serial is incremented on success.
+func (s *State) RenameModule(src, dst []string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(src) < 2 || len(dst) < 2 {
+		return fmt.Errorf("the root module can't be renamed")
+	}
+	if modulePathHasPrefix(dst, src) {
+		return fmt.Errorf(
+			"can't rename module %s into itself: %s",
+			modulePrefixStr(src), modulePrefixStr(dst))
+	}
+	if s.moduleByPath(dst) != nil {
+		return fmt.Errorf("module already exists: %s", modulePrefixStr(dst))
+	}
+
+	found := false
+	for _, mod := range s.Modules {
+		if mod == nil || !modulePathHasPrefix(mod.Path, src) {
+			continue
+		}
+
+		path := make([]string, 0, len(dst)+len(mod.Path)-len(src))
+		path = append(path, dst...)
+		path = append(path, mod.Path[len(src):]...)
+		mod.Path = path
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("module not found in state: %s", modulePrefixStr(src))
+	}
+
+	parent := src[:len(src)-1]
+	if reflect.DeepEqual(parent, dst[:len(dst)-1]) {
+		from := "module." + src[len(src)-1]
+		to := "module." + dst[len(dst)-1]
+		for _, mod := range s.Modules {
+			if mod == nil {
+				continue
+			}
+
+			if reflect.DeepEqual(mod.Path, parent) {
+				mod.renameDependencies(from, to)
+			}
+			if len(mod.Path) == len(parent)+1 && modulePathHasPrefix(mod.Path, parent) {
+				mod.Dependencies = renameDependencyList(mod.Dependencies, from, to)
+			}
+		}
+	}
+
+	s.sort()
+	s.incrementSerialLocked()
+	return nil
+}
+
+// Sanitize removes attributes whose value is empty or "<nil>" from every
+// instance in the state. Such values accumulate when attributes are
+// removed from provider schemas. The removed attributes are returned as
+// sorted paths of the form "module.foo/aws_instance.bar/attr", with the
+// module part omitted in the root module and a "deposed.N" part added for
+// deposed instances.
+func (s *State) Sanitize() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	var removed []string
+	for _, mod := range s.Modules {
+		if mod == nil {
+			continue
+		}
+
+		prefix := modulePrefixStr(mod.Path)
+		for k, r := range mod.Resources {
+			if r == nil {
+				continue
+			}
+
+			base := k
+			if prefix != "" {
+				base = prefix + "/" + k
+			}
+
+			removed = append(removed, r.Primary.sanitize(base)...)
+			for i, d := range r.Deposed {
+				removed = append(removed, d.sanitize(
+					fmt.Sprintf("%s/deposed.%d", base, i))...)
+			}
+		}
+	}
+
+	sort.Strings(removed)
+	return removed
+}
+
+// renameDependencies rewrites dependencies of the module's resources on
+// from, along with anything within it, to refer to to instead.
+func (m *ModuleState) renameDependencies(from, to string) {
+	for _, r := range m.Resources {
+		r.Dependencies = renameDependencyList(r.Dependencies, from, to)
+	}
+}
+
+// removeDependencies removes dependencies on name from every resource in
+// the module. If all is true, dependencies on anything within name, such
+// as "name.0" or "name.*", are removed too.
+func (m *ModuleState) removeDependencies(name string, all bool) {
+	for _, r := range m.Resources {
+		deps := r.Dependencies[:0]
+		for _, dep := range r.Dependencies {
+			if dep == name || (all && strings.HasPrefix(dep, name+".")) {
+				continue
+			}
+
+			deps = append(deps, dep)
+		}
+		r.Dependencies = deps
+	}
+}
+
+// renameDependencyList rewrites the entries of deps that refer to from,
+// or to anything within it such as "from.0", to refer to to instead.
+func renameDependencyList(deps []string, from, to string) []string {
+	for i, dep := range deps {
+		if dep == from {
+			deps[i] = to
+		} else if strings.HasPrefix(dep, from+".") {
+			deps[i] = to + dep[len(from):]
+		}
+	}
+
+	return deps
+}
+
 // RootModule returns the ModuleState for the root module
 func (s *State) RootModule() *ModuleState {
 	root := s.ModuleByPath(rootModulePath)
@@ -605,6 +862,24 @@ func (s *State) DeepCopy() *State {
 	return copy.(*State)
 }
 
+// IncrementSerial increments the serial number of this state, sets
+// LastUpdated, and returns the new serial. This is safe to call
+// concurrently.
+func (s *State) IncrementSerial() int64 {
+	s.Lock()
+	defer s.Unlock()
+
+	s.incrementSerialLocked()
+	return s.Serial
+}
+
+// incrementSerialLocked is IncrementSerial for callers that already hold
+// the state's lock.
+func (s *State) incrementSerialLocked() {
+	s.Serial++
+	s.LastUpdated = time.Now().UTC()
+}
+
 // IncrementSerialMaybe increments the serial number of this state
 // if it different from the other state.
 func (s *State) IncrementSerialMaybe(other *State) {
@@ -625,7 +900,7 @@ func (s *State) IncrementSerialMaybe(other *State) {
 			s.Serial = other.Serial
 		}
 
-		s.Serial++
+		s.incrementSerialLocked()
 	}
 }
 
@@ -733,6 +1008,70 @@ func (s *State) prune() {
 	}
 }
 
+// Compact removes data from the state that no longer has any effect:
+// resources with no instances, empty deposed instances, outputs that
+// were never computed, and dependencies that are duplicated or refer to
+// resources or modules that are no longer in the state.
+func (s *State) Compact() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.prune()
+	for _, mod := range s.Modules {
+		for _, r := range mod.Resources {
+			r.Dependencies = s.compactDependencies(mod, r.Dependencies)
+		}
+	}
+}
+
+// compactDependencies returns deps without duplicates or dependencies on
+// things that don't exist relative to the module mod.
+func (s *State) compactDependencies(mod *ModuleState, deps []string) []string {
+	seen := make(map[string]bool)
+	result := deps[:0]
+	for _, dep := range deps {
+		if seen[dep] {
+			continue
+		}
+		seen[dep] = true
+
+		if strings.HasPrefix(dep, "module.") {
+			path := append(mod.Path[:len(mod.Path):len(mod.Path)], dep[len("module."):])
+			if s.moduleByPath(path) == nil {
+				continue
+			}
+		} else if !mod.hasResource(dep) {
+			continue
+		}
+
+		result = append(result, dep)
+	}
+
+	return result
+}
+
+// hasResource returns true if the module has any instance of the
+// resource named by dep, which may include an index or a ".*" suffix.
+func (m *ModuleState) hasResource(dep string) bool {
+	parts := strings.Split(dep, ".")
+	n := 2
+	if parts[0] == "data" {
+		n = 3
+	}
+	if len(parts) < n {
+		return false
+	}
+	name := strings.Join(parts[:n], ".")
+
+	for k := range m.Resources {
+		if k == name || strings.HasPrefix(k, name+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // sort sorts the modules
 func (s *State) sort() {
 	sort.Sort(moduleStateSort(s.Modules))
@@ -1332,7 +1671,6 @@ func ParseResourceStateKey(k string) (*ResourceStateKey, error) {
 //
 // Extra is just extra data that a provider can return that we store
 // for later, but is not exposed in any way to the user.
-//
 type ResourceState struct {
 	// This is filled in and managed by Terraform, and is the resource
 	// type itself such as "mycloud_instance". If a resource provider sets
@@ -1530,6 +1868,27 @@ type InstanceState struct {
 func (s *InstanceState) Lock()   { s.mu.Lock() }
 func (s *InstanceState) Unlock() { s.mu.Unlock() }
 
+// sanitize removes attributes with an empty or "<nil>" value, returning
+// their keys prefixed by the given path.
+func (s *InstanceState) sanitize(path string) []string {
+	if s == nil {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	var removed []string
+	for k, v := range s.Attributes {
+		if v == "" || v == "<nil>" {
+			delete(s.Attributes, k)
+			removed = append(removed, path+"/"+k)
+		}
+	}
+
+	return removed
+}
+
 func (s *InstanceState) init() {
 	s.Lock()
 	defer s.Unlock()
@@ -1794,7 +2153,7 @@ func ReadState(src io.Reader) (*State, error) {
 		}
 
 		// increment the Serial whenever we upgrade state
-		v3State.Serial++
+		v3State.IncrementSerial()
 		result = v3State
 	case 2:
 		v2State, err := ReadStateV2(jsonBytes)
@@ -1806,7 +2165,7 @@ func ReadState(src io.Reader) (*State, error) {
 			return nil, err
 		}
 
-		v3State.Serial++
+		v3State.IncrementSerial()
 		result = v3State
 	case 3:
 		v3State, err := ReadStateV3(jsonBytes)
@@ -1929,7 +2288,7 @@ func ReadStateV3(jsonBytes []byte) (*State, error) {
 
 	if !bytes.Equal(jsonBytes, buf.Bytes()) {
 		log.Println("[INFO] state modified during read or write. incrementing serial number")
-		state.Serial++
+		state.IncrementSerial()
 	}
 
 	return state, nil
@@ -1959,23 +2318,86 @@ func WriteState(d *State, dst io.Writer) error {
 		}
 	}
 
-	// Encode the data in a human-friendly way
-	data, err := json.MarshalIndent(d, "", "    ")
+	// Encode the data in a human-friendly way. The modules are encoded and
+	// written one at a time, so that a large state is never held in memory
+	// twice over. The output is the same as json.MarshalIndent(d, "", "    "),
+	// except that a zero LastUpdated is left out.
+	h := &stateHeader{
+		Version:   d.Version,
+		TFVersion: d.TFVersion,
+		Serial:    d.Serial,
+		Lineage:   d.Lineage,
+		Remote:    d.Remote,
+	}
+	if !d.LastUpdated.IsZero() {
+		updated := d.LastUpdated
+		h.LastUpdated = &updated
+	}
+	header, err := json.MarshalIndent(h, "", "    ")
 	if err != nil {
 		return fmt.Errorf("Failed to encode state: %s", err)
 	}
 
-	// We append a newline to the data because MarshalIndent doesn't
-	data = append(data, '\n')
+	// Drop the closing brace so the modules can follow the header
+	header = bytes.TrimSuffix(header, []byte("\n}"))
+
+	w := &stateWriter{w: dst}
+	w.write(header)
+	w.write([]byte(",\n    \"modules\": ["))
+	var buf bytes.Buffer
+	for i, m := range d.Modules {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("Failed to encode state: %s", err)
+		}
 
-	// Write the data out to the dst
-	if _, err := io.Copy(dst, bytes.NewReader(data)); err != nil {
-		return fmt.Errorf("Failed to write state: %v", err)
+		buf.Reset()
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString("\n        ")
+		if err := json.Indent(&buf, data, "        ", "    "); err != nil {
+			return fmt.Errorf("Failed to encode state: %s", err)
+		}
+		w.write(buf.Bytes())
+	}
+	if len(d.Modules) > 0 {
+		w.write([]byte("\n    "))
+	}
+	w.write([]byte("]\n}\n"))
+
+	if w.err != nil {
+		return fmt.Errorf("Failed to write state: %v", w.err)
 	}
 
 	return nil
 }
 
+// stateHeader is the part of a State that WriteState encodes before the
+// modules. Its fields must match those of State, except that LastUpdated
+// is a pointer so that it can be left out while it is zero.
+// TestStateHeader_fields checks that the two don't drift apart.
+type stateHeader struct {
+	Version     int          `json:"version"`
+	TFVersion   string       `json:"terraform_version,omitempty"`
+	Serial      int64        `json:"serial"`
+	LastUpdated *time.Time   `json:"last_updated,omitempty"`
+	Lineage     string       `json:"lineage"`
+	Remote      *RemoteState `json:"remote,omitempty"`
+}
+
+// stateWriter writes to w until the first error, which it keeps.
+type stateWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *stateWriter) write(p []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(p)
+	}
+}
+
 // moduleStateSort implements sort.Interface to sort module states
 type moduleStateSort []*ModuleState
 
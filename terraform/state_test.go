@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/terraform/config"
 )
@@ -573,6 +576,406 @@ func TestStateSameLineage(t *testing.T) {
 	}
 }
 
+func TestStateRenameModule(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{
+				Path: rootModulePath,
+				Resources: map[string]*ResourceState{
+					"aws_instance.foo": &ResourceState{
+						Type:         "aws_instance",
+						Dependencies: []string{"module.old"},
+						Primary: &InstanceState{
+							ID: "foo",
+						},
+					},
+				},
+			},
+			&ModuleState{
+				Path:         []string{"root", "other"},
+				Dependencies: []string{"module.old"},
+			},
+			&ModuleState{
+				Path: []string{"root", "old"},
+				Resources: map[string]*ResourceState{
+					"aws_instance.bar": &ResourceState{
+						Type: "aws_instance",
+						Primary: &InstanceState{
+							ID: "bar",
+						},
+					},
+				},
+			},
+			&ModuleState{
+				Path: []string{"root", "old", "child"},
+				Resources: map[string]*ResourceState{
+					"aws_instance.baz": &ResourceState{
+						Type: "aws_instance",
+						Primary: &InstanceState{
+							ID: "baz",
+						},
+					},
+				},
+			},
+		},
+	}
+	state.init()
+
+	if err := state.RenameModule(
+		[]string{"root", "old"}, []string{"root", "new"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, mod := range state.Modules {
+		if modulePathHasPrefix(mod.Path, []string{"root", "old"}) {
+			t.Fatalf("module still at old path: %#v", mod.Path)
+		}
+	}
+	if state.ModuleByPath([]string{"root", "new"}) == nil {
+		t.Fatal("module not at new path")
+	}
+	if state.ModuleByPath([]string{"root", "new", "child"}) == nil {
+		t.Fatal("child module not at new path")
+	}
+
+	deps := state.RootModule().Resources["aws_instance.foo"].Dependencies
+	if !reflect.DeepEqual(deps, []string{"module.new"}) {
+		t.Fatalf("bad resource dependencies: %#v", deps)
+	}
+	deps = state.ModuleByPath([]string{"root", "other"}).Dependencies
+	if !reflect.DeepEqual(deps, []string{"module.new"}) {
+		t.Fatalf("bad module dependencies: %#v", deps)
+	}
+
+	if state.Serial != 1 {
+		t.Fatalf("bad serial: %d", state.Serial)
+	}
+}
+
+func TestStateRenameModule_exists(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{Path: []string{"root", "old"}},
+			&ModuleState{Path: []string{"root", "new"}},
+		},
+	}
+	state.init()
+
+	err := state.RenameModule([]string{"root", "old"}, []string{"root", "new"})
+	if err == nil {
+		t.Fatal("should error")
+	}
+	if state.Serial != 0 {
+		t.Fatalf("bad serial: %d", state.Serial)
+	}
+}
+
+func TestStateRemoveResource(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{
+				Path: []string{"root", "child"},
+				Resources: map[string]*ResourceState{
+					"aws_instance.foo.0": &ResourceState{
+						Type:    "aws_instance",
+						Primary: &InstanceState{ID: "foo0"},
+					},
+					"aws_instance.foo.1": &ResourceState{
+						Type:    "aws_instance",
+						Primary: &InstanceState{ID: "foo1"},
+					},
+					"aws_instance.bar": &ResourceState{
+						Type: "aws_instance",
+						Dependencies: []string{
+							"aws_instance.foo",
+							"aws_instance.foo.0",
+							"aws_instance.foo.*",
+						},
+						Primary: &InstanceState{ID: "bar"},
+					},
+				},
+			},
+		},
+	}
+	state.init()
+
+	addr, err := ParseResourceAddress("module.child.aws_instance.foo[0]")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := state.RemoveResource(addr); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	mod := state.ModuleByPath([]string{"root", "child"})
+	if _, ok := mod.Resources["aws_instance.foo.0"]; ok {
+		t.Fatal("resource should be removed")
+	}
+	if len(mod.Resources) != 2 {
+		t.Fatalf("bad resources: %#v", mod.Resources)
+	}
+
+	// Another instance remains, so only the instance dependency goes
+	deps := mod.Resources["aws_instance.bar"].Dependencies
+	expected := []string{"aws_instance.foo", "aws_instance.foo.*"}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Fatalf("bad dependencies: %#v", deps)
+	}
+
+	addr.Index = 1
+	if err := state.RemoveResource(addr); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(mod.Resources) != 1 {
+		t.Fatalf("bad resources: %#v", mod.Resources)
+	}
+	if deps := mod.Resources["aws_instance.bar"].Dependencies; len(deps) != 0 {
+		t.Fatalf("bad dependencies: %#v", deps)
+	}
+
+	if state.Serial != 2 {
+		t.Fatalf("bad serial: %d", state.Serial)
+	}
+}
+
+func TestStateRemoveResource_notFound(t *testing.T) {
+	state := NewState()
+
+	addr, err := ParseResourceAddress("aws_instance.foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := state.RemoveResource(addr); err != ErrResourceNotFound {
+		t.Fatalf("expected ErrResourceNotFound, got: %v", err)
+	}
+	if state.Serial != 0 {
+		t.Fatalf("bad serial: %d", state.Serial)
+	}
+}
+
+func TestStateExportResources(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{
+				Path: rootModulePath,
+				Resources: map[string]*ResourceState{
+					"aws_instance.foo.1": &ResourceState{Type: "aws_instance"},
+					"aws_instance.foo.0": &ResourceState{Type: "aws_instance"},
+					"do_droplet.bar":     &ResourceState{Type: "do_droplet"},
+				},
+			},
+			&ModuleState{
+				Path: []string{"root", "child"},
+				Resources: map[string]*ResourceState{
+					"data.aws_ami.baz": &ResourceState{Type: "aws_ami"},
+				},
+			},
+		},
+	}
+	state.init()
+
+	filter := func(r *ResourceState) bool {
+		return strings.HasPrefix(r.Type, "aws_")
+	}
+
+	resources := state.ExportResources(filter)
+	if len(resources) != 3 {
+		t.Fatalf("bad: %#v", resources)
+	}
+	for _, r := range resources {
+		if r.Type == "do_droplet" {
+			t.Fatalf("bad: %#v", resources)
+		}
+	}
+
+	var actual []string
+	for _, addr := range state.ExportResourceAddresses(filter) {
+		actual = append(actual, addr.String())
+	}
+	expected := []string{
+		"aws_instance.foo[0]",
+		"aws_instance.foo[1]",
+		"module.child.data.aws_ami.baz",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestStateCompact(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{
+				Path: rootModulePath,
+				Resources: map[string]*ResourceState{
+					"aws_instance.foo.0": &ResourceState{
+						Type:    "aws_instance",
+						Primary: &InstanceState{ID: "foo"},
+					},
+					"data.aws_ami.ami": &ResourceState{
+						Type:    "aws_ami",
+						Primary: &InstanceState{ID: "ami"},
+					},
+					"aws_instance.bar": &ResourceState{
+						Type: "aws_instance",
+						Dependencies: []string{
+							"aws_instance.foo",
+							"aws_instance.foo.*",
+							"aws_instance.foo",
+							"aws_instance.gone",
+							"data.aws_ami.ami",
+							"module.child",
+							"module.gone",
+						},
+						Primary: &InstanceState{ID: "bar"},
+						Deposed: []*InstanceState{
+							&InstanceState{},
+						},
+					},
+					"aws_instance.empty": &ResourceState{
+						Type: "aws_instance",
+					},
+				},
+			},
+			&ModuleState{
+				Path: []string{"root", "child"},
+				Resources: map[string]*ResourceState{
+					"aws_instance.baz": &ResourceState{
+						Type:    "aws_instance",
+						Primary: &InstanceState{ID: "baz"},
+					},
+				},
+			},
+		},
+	}
+	state.init()
+
+	state.Compact()
+
+	root := state.RootModule()
+	if _, ok := root.Resources["aws_instance.empty"]; ok {
+		t.Fatal("resource with no instances should be removed")
+	}
+
+	bar := root.Resources["aws_instance.bar"]
+	if len(bar.Deposed) != 0 {
+		t.Fatalf("bad deposed: %#v", bar.Deposed)
+	}
+
+	expected := []string{
+		"aws_instance.foo",
+		"aws_instance.foo.*",
+		"data.aws_ami.ami",
+		"module.child",
+	}
+	if !reflect.DeepEqual(bar.Dependencies, expected) {
+		t.Fatalf("bad dependencies: %#v", bar.Dependencies)
+	}
+}
+
+func TestStateSanitize(t *testing.T) {
+	state := &State{
+		Modules: []*ModuleState{
+			&ModuleState{
+				Path: rootModulePath,
+				Resources: map[string]*ResourceState{
+					"aws_instance.foo": &ResourceState{
+						Type: "aws_instance",
+						Primary: &InstanceState{
+							ID: "foo",
+							Attributes: map[string]string{
+								"id":      "foo",
+								"removed": "",
+							},
+						},
+						Deposed: []*InstanceState{
+							&InstanceState{
+								ID: "old",
+								Attributes: map[string]string{
+									"id":  "old",
+									"nil": "<nil>",
+								},
+							},
+						},
+					},
+				},
+			},
+			&ModuleState{
+				Path: []string{"root", "vpc"},
+				Resources: map[string]*ResourceState{
+					"aws_vpc.main": &ResourceState{
+						Type: "aws_vpc",
+						Primary: &InstanceState{
+							ID: "main",
+							Attributes: map[string]string{
+								"id":     "main",
+								"tags.#": "",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	state.init()
+
+	actual := state.Sanitize()
+	expected := []string{
+		"aws_instance.foo/deposed.0/nil",
+		"aws_instance.foo/removed",
+		"module.vpc/aws_vpc.main/tags.#",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	attrs := state.RootModule().Resources["aws_instance.foo"].Primary.Attributes
+	if !reflect.DeepEqual(attrs, map[string]string{"id": "foo"}) {
+		t.Fatalf("bad: %#v", attrs)
+	}
+
+	if actual := state.Sanitize(); len(actual) != 0 {
+		t.Fatalf("second sanitize should be a no-op: %#v", actual)
+	}
+}
+
+func TestStateIncrementSerial(t *testing.T) {
+	s := &State{Serial: 3}
+	start := time.Now().UTC()
+
+	var wg sync.WaitGroup
+	seen := make(chan int64, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			seen <- s.IncrementSerial()
+		}()
+	}
+	wg.Wait()
+	close(seen)
+
+	unique := make(map[int64]struct{})
+	for serial := range seen {
+		unique[serial] = struct{}{}
+	}
+	if len(unique) != 50 {
+		t.Fatalf("expected 50 unique serials, got %d", len(unique))
+	}
+
+	if s.Serial != 53 {
+		t.Fatalf("bad: %d", s.Serial)
+	}
+
+	if actual := s.IncrementSerial(); actual != s.Serial {
+		t.Fatalf("returned %d, serial is %d", actual, s.Serial)
+	}
+
+	if s.LastUpdated.Before(start) {
+		t.Fatalf("LastUpdated not set: %s", s.LastUpdated)
+	}
+}
+
 func TestStateIncrementSerialMaybe(t *testing.T) {
 	cases := map[string]struct {
 		S1, S2 *State
@@ -1565,6 +1968,135 @@ func TestReadStateTFVersion(t *testing.T) {
 	}
 }
 
+func TestWriteState_marshalIndent(t *testing.T) {
+	updated := time.Date(2017, 1, 2, 3, 4, 5, 6, time.UTC)
+	large := testLargeState(3, 20)
+	large.LastUpdated = updated
+
+	cases := map[string]*State{
+		"empty": &State{LastUpdated: updated},
+		"remote": &State{
+			Serial:      3,
+			TFVersion:   "0.8.0",
+			LastUpdated: updated,
+			Lineage:     "5d1ad1a1-4027-4665-a908-dbe6adff11d8",
+			Remote: &RemoteState{
+				Type:   "http",
+				Config: map[string]string{"url": "http://example.com/<state>"},
+			},
+		},
+		"large": large,
+	}
+
+	for name, state := range cases {
+		buf := new(bytes.Buffer)
+		if err := WriteState(state, buf); err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+
+		// The streamed output must be exactly what encoding the whole
+		// state at once gives, so that checksums of it don't change.
+		expected, err := json.MarshalIndent(state, "", "    ")
+		if err != nil {
+			t.Fatalf("%s: err: %s", name, err)
+		}
+		expected = append(expected, '\n')
+
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Fatalf("%s: bad:\n%s\n\nexpected:\n%s", name, buf.Bytes(), expected)
+		}
+	}
+}
+
+func TestWriteState_noLastUpdated(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := WriteState(&State{Serial: 1}, buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// A state that never recorded LastUpdated is written as before
+	if strings.Contains(buf.String(), "last_updated") {
+		t.Fatalf("bad:\n%s", buf.String())
+	}
+}
+
+func TestStateHeader_fields(t *testing.T) {
+	// stateHeader must carry every encoded field of State other than the
+	// modules, in the same order, or WriteState would drop it.
+	var expected, actual []reflect.StructField
+	st := reflect.TypeOf(State{})
+	for i := 0; i < st.NumField(); i++ {
+		f := st.Field(i)
+		if f.PkgPath != "" || f.Name == "Modules" {
+			continue
+		}
+		if f.Name == "LastUpdated" {
+			f.Type = reflect.PtrTo(f.Type)
+		}
+		expected = append(expected, f)
+	}
+	ht := reflect.TypeOf(stateHeader{})
+	for i := 0; i < ht.NumField(); i++ {
+		actual = append(actual, ht.Field(i))
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("bad: stateHeader has %d fields, State has %d",
+			len(actual), len(expected))
+	}
+	for i, f := range expected {
+		h := actual[i]
+		if h.Name != f.Name || h.Type != f.Type || h.Tag != f.Tag {
+			t.Fatalf("bad: stateHeader field %s %s %q, State field %s %s %q",
+				h.Name, h.Type, h.Tag, f.Name, f.Type, f.Tag)
+		}
+	}
+}
+
+func BenchmarkWriteState(b *testing.B) {
+	state := testLargeState(10, 500)
+
+	// Sort and initialize once so that only the encoding is measured
+	if err := WriteState(state, ioutil.Discard); err != nil {
+		b.Fatalf("err: %s", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := WriteState(state, ioutil.Discard); err != nil {
+			b.Fatalf("err: %s", err)
+		}
+	}
+}
+
+// testLargeState returns a state with the given number of child modules,
+// each with the given number of resources.
+func testLargeState(modules, resources int) *State {
+	state := NewState()
+	state.Lineage = "5d1ad1a1-4027-4665-a908-dbe6adff11d8"
+	for i := 0; i < modules; i++ {
+		mod := state.AddModule([]string{"root", fmt.Sprintf("child%d", i)})
+		for j := 0; j < resources; j++ {
+			mod.Resources[fmt.Sprintf("aws_instance.foo.%d", j)] = &ResourceState{
+				Type: "aws_instance",
+				Primary: &InstanceState{
+					ID: fmt.Sprintf("i-%08d", j),
+					Attributes: map[string]string{
+						"id":            fmt.Sprintf("i-%08d", j),
+						"ami":           "ami-abcd1234",
+						"instance_type": "t2.micro",
+						"tags.%":        "1",
+						"tags.Name":     fmt.Sprintf("foo-%d", j),
+					},
+				},
+			}
+		}
+	}
+
+	return state
+}
+
 func TestWriteStateTFVersion(t *testing.T) {
 	cases := []struct {
 		Write string
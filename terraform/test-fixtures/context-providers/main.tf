@@ -0,0 +1,11 @@
+provider "aws" {
+    alias = "west"
+}
+
+resource "aws_instance" "foo" {
+    provider = "aws.west"
+}
+
+module "child" {
+    source = "./child"
+}
@@ -0,0 +1,7 @@
+module "vpc" {
+    source = "./vpc"
+}
+
+module "db" {
+    source = "./db"
+}
@@ -0,0 +1,7 @@
+resource "aws_vpc" "main" {
+    foo = "bar"
+}
+
+module "subnets" {
+    source = "./subnets"
+}
@@ -0,0 +1,3 @@
+resource "aws_subnet" "main" {
+    foo = "bar"
+}
@@ -127,11 +127,35 @@ func (t *TargetsTransformer) nodeIsTarget(
 		if targetAddr.Equals(addr) {
 			return true
 		}
+
+		// A target that names only a module, such as "module.foo",
+		// targets everything within that module's tree, including
+		// its descendent modules.
+		if targetAddr.Type == "" && targetAddr.Name == "" &&
+			modulePathHasPrefix(addr.Path, targetAddr.Path) {
+			return true
+		}
 	}
 
 	return false
 }
 
+// modulePathHasPrefix returns true if the module path p is, or is a
+// descendent of, the module path prefix.
+func modulePathHasPrefix(p, prefix []string) bool {
+	if len(prefix) == 0 || len(p) < len(prefix) {
+		return false
+	}
+
+	for i, v := range prefix {
+		if p[i] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
 // RemovableIfNotTargeted is a special interface for graph nodes that
 // aren't directly addressable, but need to be removed from the graph when they
 // are not targeted. (Nodes that are not directly targeted end up in the set of
@@ -0,0 +1,50 @@
+package terraform
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeoutUIInput is an implementation of UIInput that gives up waiting
+// for an answer after Timeout. On timeout, the question's default is
+// returned if UseDefault is set, otherwise an error is returned.
+//
+// The wrapped UIInput isn't interrupted on timeout, so its answer to the
+// abandoned question, if it ever comes, is discarded.
+type TimeoutUIInput struct {
+	Timeout    time.Duration
+	UseDefault bool
+	UIInput    UIInput
+}
+
+func (i *TimeoutUIInput) Input(opts *InputOpts) (string, error) {
+	if i.Timeout <= 0 {
+		return i.UIInput.Input(opts)
+	}
+
+	type result struct {
+		value string
+		err   error
+	}
+
+	// Buffered so the goroutine can always exit after a timeout
+	resultCh := make(chan result, 1)
+	go func() {
+		v, err := i.UIInput.Input(opts)
+		resultCh <- result{value: v, err: err}
+	}()
+
+	timer := time.NewTimer(i.Timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-resultCh:
+		return r.value, r.err
+	case <-timer.C:
+		if i.UseDefault {
+			return opts.Default, nil
+		}
+
+		return "", fmt.Errorf("input for %q timed out after %s", opts.Id, i.Timeout)
+	}
+}
@@ -0,0 +1,61 @@
+package terraform
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeoutUIInput_impl(t *testing.T) {
+	var _ UIInput = new(TimeoutUIInput)
+}
+
+func TestTimeoutUIInput(t *testing.T) {
+	input := &MockUIInput{InputReturnString: "answer"}
+	timeout := &TimeoutUIInput{
+		Timeout: time.Minute,
+		UIInput: input,
+	}
+
+	v, err := timeout.Input(&InputOpts{Id: "foo"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v != "answer" {
+		t.Fatalf("bad: %q", v)
+	}
+}
+
+func TestTimeoutUIInput_timeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	blockingInput := func() UIInput {
+		return &MockUIInput{
+			InputFn: func(*InputOpts) (string, error) {
+				<-block
+				return "late", nil
+			},
+		}
+	}
+
+	timeout := &TimeoutUIInput{
+		Timeout: 10 * time.Millisecond,
+		UIInput: blockingInput(),
+	}
+	if _, err := timeout.Input(&InputOpts{Id: "foo"}); err == nil {
+		t.Fatal("should error")
+	}
+
+	timeout = &TimeoutUIInput{
+		Timeout:    10 * time.Millisecond,
+		UseDefault: true,
+		UIInput:    blockingInput(),
+	}
+	v, err := timeout.Input(&InputOpts{Id: "foo", Default: "default"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v != "default" {
+		t.Fatalf("bad: %q", v)
+	}
+}